@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+// 缓存策略分类，路由按分类取 cache_control 中对应的 Cache-Control
+const (
+	cacheImages = "images"
+	cacheLists  = "lists"
+	cacheAPI    = "api"
+	// cacheAuth 需要鉴权的监听上的全部响应
+	cacheAuth = "auth"
+)
+
+var defaultCacheControl = map[string]string{
+	cacheImages: "public, max-age=31536000, immutable",
+	cacheLists:  "public, max-age=60, stale-while-revalidate=600",
+	cacheAPI:    "public, max-age=300",
+	cacheAuth:   "private, no-store",
+}
+
+// cacheControl 返回当前请求成功时应使用的 Cache-Control，没有配置时返回空
+func (c *Context) cacheControl() string {
+	if p := c.policy(); p != nil && p.authenticated {
+		return conf().CacheControl[cacheAuth]
+	}
+	return conf().CacheControl[c.cache]
+}
+
+// addVary 向 Vary 追加字段，已存在的字段不会重复添加
+func addVary(h http.Header, fields ...string) {
+	for _, f := range fields {
+		exists := false
+		for _, v := range h.Values("Vary") {
+			for _, s := range strings.Split(v, ",") {
+				if strings.EqualFold(strings.TrimSpace(s), f) {
+					exists = true
+				}
+			}
+		}
+		if !exists {
+			h.Add("Vary", f)
+		}
+	}
+}
+
+// etag 由内容的 sha256 生成强 ETag
+func etag(b []byte) string {
+	sum := sha256.Sum256(b)
+	return `"` + base64.RawURLEncoding.EncodeToString(sum[:18]) + `"`
+}
+
+// etagMatch 判断 If-None-Match 是否包含 tag
+func etagMatch(header, tag string) bool {
+	for _, s := range strings.Split(header, ",") {
+		s = strings.TrimSpace(s)
+		if s == "*" || strings.TrimPrefix(s, "W/") == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// writeCacheable 写出由代理生成的内容，按内容加上 ETag 和缓存策略，
+// 客户端的 If-None-Match 命中时返回 304
+func (c *Context) writeCacheable(b []byte) {
+	h := c.rw.Header()
+	if h.Get("Cache-Control") == "" {
+		if cc := c.cacheControl(); cc != "" {
+			h.Set("Cache-Control", cc)
+		} else {
+			h.Set("Cache-Control", "no-cache")
+		}
+	}
+	tag := etag(b)
+	h.Set("ETag", tag)
+	if inm := c.req.Header.Get("If-None-Match"); inm != "" && etagMatch(inm, tag) {
+		h.Del("Content-Type")
+		c.rw.WriteHeader(http.StatusNotModified)
+		return
+	}
+	c.write(b, http.StatusOK)
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"go-pixiv-proxy/pixiv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// /api/comments 每次返回的顶层评论数量
+const (
+	commentPageSize    = 20
+	commentMaxPageSize = 50
+	// commentMaxReplyPages 每条评论最多获取的回复页数
+	commentMaxReplyPages = 10
+	// commentReplyWorkers 同时获取回复的请求数
+	commentReplyWorkers = 4
+)
+
+// commentEmojiRe 评论正文中的表情，如 (normal)
+var commentEmojiRe = regexp.MustCompile(`\(([a-z]+\d?)\)`)
+
+type Comment struct {
+	ID      int64  `json:"id"`
+	Comment string `json:"comment"`
+	Date    string `json:"date"`
+	User    User   `json:"user"`
+	// ReplyToUser 回复的对象，只在回复中出现
+	ReplyToUser *User         `json:"reply_to_user,omitempty"`
+	Stamp       *CommentStamp `json:"stamp,omitempty"`
+	// Emojis 正文中出现的表情名称及其图片地址
+	Emojis     map[string]string `json:"emojis,omitempty"`
+	HasReplies bool              `json:"has_replies"`
+	// ReplyCount 回复数量，pixiv 不返回该值，只在获取了全部回复时给出
+	ReplyCount *int      `json:"reply_count,omitempty"`
+	Replies    []Comment `json:"replies,omitempty"`
+	// RepliesTruncated 回复超过 commentMaxReplyPages 页，replies 不完整
+	RepliesTruncated bool `json:"replies_truncated,omitempty"`
+}
+
+type CommentStamp struct {
+	StampID  int64  `json:"stamp_id"`
+	StampURL string `json:"stamp_url"`
+}
+
+// CommentList /api/comments 的返回
+type CommentList struct {
+	Comments []Comment `json:"comments"`
+	Length   int       `json:"length"`
+	NextURL  string    `json:"next_url,omitempty"`
+}
+
+// commentDate pixiv 返回的评论时间为日本时间的 2006-01-02 15:04
+func commentDate(s string) string {
+	t, err := time.ParseInLocation("2006-01-02 15:04", s, jst)
+	if err != nil {
+		return s
+	}
+	return t.Format(time.RFC3339)
+}
+
+func (c *Context) commentFromPixiv(cm *pixiv.Comment) Comment {
+	ret := Comment{
+		ID:      parseID(cm.ID),
+		Comment: cm.Comment,
+		Date:    commentDate(cm.CommentDate),
+		User: User{
+			ID:               parseID(cm.UserID),
+			Name:             cm.UserName,
+			ProfileImageURLs: &ProfileImageURLs{Medium: c.proxyImageURL(cm.Img)},
+		},
+		HasReplies: cm.HasReplies,
+	}
+	if cm.ReplyToUserID != "" && cm.ReplyToUserID != "0" {
+		ret.ReplyToUser = &User{ID: parseID(cm.ReplyToUserID), Name: cm.ReplyToUserName}
+	}
+	if cm.StampID != "" {
+		ret.Stamp = &CommentStamp{StampID: parseID(cm.StampID), StampURL: c.staticURL(pixiv.StampPath(cm.StampID))}
+	}
+	for _, m := range commentEmojiRe.FindAllStringSubmatch(cm.Comment, -1) {
+		id, ok := pixiv.Emojis[m[1]]
+		if !ok {
+			continue
+		}
+		if ret.Emojis == nil {
+			ret.Emojis = map[string]string{}
+		}
+		ret.Emojis[m[1]] = c.staticURL(pixiv.EmojiPath(id))
+	}
+	return ret
+}
+
+// commentReplies 获取一条评论的全部回复，按时间从旧到新，
+// 超过 commentMaxReplyPages 页时只返回前面的部分，complete 为 false
+func (c *Context) commentReplies(ctx context.Context, id string) (replies []Comment, complete bool, err error) {
+	for page := 1; page <= commentMaxReplyPages; page++ {
+		res, err := pixivClient().CommentReplies(ctx, id, page)
+		if err != nil {
+			return nil, false, err
+		}
+		for i := range res.Comments {
+			replies = append(replies, c.commentFromPixiv(&res.Comments[i]))
+		}
+		if !res.HasNext {
+			return replies, true, nil
+		}
+	}
+	return replies, false, nil
+}
+
+// handleApiComments 作品的评论，offset 从 0 开始，replies=0 时不获取回复
+func handleApiComments(c *Context) {
+	query := c.req.URL.Query()
+	pid := query.Get("pid")
+	if !isDigits(pid) {
+		c.Error(400, "pid invalid")
+		return
+	}
+	offset, limit := 0, commentPageSize
+	if s := query.Get("offset"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			c.Error(400, "offset invalid")
+			return
+		}
+		offset = n
+	}
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > commentMaxPageSize {
+			c.Error(400, "limit invalid")
+			return
+		}
+		limit = n
+	}
+	ctx := c.req.Context()
+	if c.filter() != nil {
+		illust, err := pixivClient().Illust(ctx, pid)
+		if err != nil {
+			c.PixivError(err)
+			return
+		}
+		if c.blockedWork(illust) {
+			c.Error(http.StatusUnavailableForLegalReasons, "this work is filtered")
+			return
+		}
+	}
+	res, err := pixivClient().CommentRoots(ctx, pid, offset, limit)
+	if err != nil {
+		c.PixivError(err)
+		return
+	}
+	ret := &CommentList{Comments: make([]Comment, 0, len(res.Comments))}
+	for i := range res.Comments {
+		ret.Comments = append(ret.Comments, c.commentFromPixiv(&res.Comments[i]))
+	}
+	if query.Get("replies") != "0" {
+		var (
+			wg  sync.WaitGroup
+			sem = make(chan struct{}, commentReplyWorkers)
+		)
+		for i := range ret.Comments {
+			if !ret.Comments[i].HasReplies {
+				continue
+			}
+			wg.Add(1)
+			go func(cm *Comment, id string) {
+				defer wg.Done()
+				sem <- struct{}{}
+				defer func() { <-sem }()
+				replies, complete, err := c.commentReplies(ctx, id)
+				if err != nil {
+					log.Warnf("comment %s replies: %v", id, err)
+					return
+				}
+				cm.Replies = replies
+				if complete {
+					n := len(replies)
+					cm.ReplyCount = &n
+				} else {
+					cm.RepliesTruncated = true
+				}
+			}(&ret.Comments[i], res.Comments[i].ID)
+		}
+		wg.Wait()
+	}
+	ret.Length = len(ret.Comments)
+	if res.HasNext {
+		ret.NextURL = "pid=" + pid + "&offset=" + strconv.Itoa(offset+len(res.Comments)) + "&limit=" + strconv.Itoa(limit)
+	}
+	c.JSON(200, ret)
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"go-pixiv-proxy/pixiv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Config 运行时配置，热重载时整体替换
+type Config struct {
+	// 顶层的 host/port/socket/tls/routes/auth 组成默认监听，配置了 Listeners 时被忽略
+	ListenerConfig
+	Listeners []ListenerConfig `json:"listeners"`
+
+	Domain  string `json:"domain"`
+	Cookies string `json:"cookies"`
+	// RewriteURLs 把 api 返回中的 pixiv 图片地址替换为代理地址，请求可用 rewrite=0/1 覆盖
+	RewriteURLs bool `json:"rewrite_urls"`
+
+	// Filters 内容过滤策略，名称 -> 策略
+	Filters map[string]*FilterPolicy `json:"filters"`
+	// PlaceholderImage 被过滤的图片和列表占位项使用的图片文件
+	PlaceholderImage string `json:"placeholder_image"`
+
+	Signing SigningConfig `json:"signing"`
+	// Hotlink 路由组 -> 防盗链规则
+	Hotlink map[string]*HotlinkPolicy `json:"hotlink"`
+	// CORS 路由组 -> 跨域规则
+	CORS map[string]*CORSPolicy `json:"cors"`
+	// ImageHeaders 转发图片时保留的上游响应头，Set-Cookie 等会话相关的头始终不转发
+	ImageHeaders []string `json:"image_headers"`
+	// SecurityHeaders 所有响应附加的响应头，值为空表示不设置
+	SecurityHeaders map[string]string `json:"security_headers"`
+	// CacheControl 缓存策略分类（images、lists、api、auth）-> Cache-Control
+	CacheControl map[string]string `json:"cache_control"`
+	// EmbedCrawlers 访问 pid 图片时返回嵌入页的爬虫 User-Agent（子串匹配），为空数组时关闭
+	EmbedCrawlers []string `json:"embed_crawlers"`
+	// PximgSizes 直链 /c/<W>x<H> 允许的缩略图尺寸
+	PximgSizes []string `json:"pximg_sizes"`
+
+	ReadHeaderTimeout Duration `json:"read_header_timeout"`
+	IdleTimeout       Duration `json:"idle_timeout"`
+	ShutdownTimeout   Duration `json:"shutdown_timeout"`
+
+	client      *pixiv.Client
+	placeholder []byte
+}
+
+// ListenerConfig 单个监听的地址、TLS、启用的路由组和鉴权策略
+type ListenerConfig struct {
+	Host string `json:"host"`
+	Port string `json:"port"`
+	// Socket 非空时监听 unix socket 而不是 host:port
+	Socket       string `json:"socket"`
+	TLSCert      string `json:"tls_cert"`
+	TLSKey       string `json:"tls_key"`
+	RedirectAddr string `json:"redirect_addr"`
+
+	// Routes 启用的路由组（images, api, admin, metrics），留空表示启用除 admin、metrics 以外的全部路由组
+	Routes []string   `json:"routes"`
+	Auth   AuthPolicy `json:"auth"`
+	// Filter 该监听默认使用的过滤策略名称，留空不过滤
+	Filter string `json:"filter"`
+	// RequireSignature 图片请求必须带有效的 exp 和 sig 参数
+	RequireSignature bool `json:"require_signature"`
+}
+
+// AuthPolicy 都为空时不鉴权，否则满足任意一项即可
+type AuthPolicy struct {
+	APIKeys   []string          `json:"api_keys"`
+	BasicAuth map[string]string `json:"basic_auth"`
+	// KeyFilters api key -> 过滤策略名称，覆盖监听的 filter
+	KeyFilters map[string]string `json:"key_filters"`
+}
+
+func (cfg *Config) listeners() []ListenerConfig {
+	if len(cfg.Listeners) > 0 {
+		return cfg.Listeners
+	}
+	return []ListenerConfig{cfg.ListenerConfig}
+}
+
+// signsImages 是否有提供图片的监听要求签名，此时生成的代理地址都需要签名
+func (cfg *Config) signsImages() bool {
+	for _, l := range cfg.listeners() {
+		if l.RequireSignature && l.routeEnabled(groupImages) {
+			return true
+		}
+	}
+	return false
+}
+
+// validate 检查监听引用的过滤策略、签名密钥等是否存在
+func (cfg *Config) validate() error {
+	signsImages := cfg.signsImages()
+	// 代理地址可能由其他监听生成，不能由请求的 Host 推断图片监听的地址
+	if signsImages && cfg.Domain == "" {
+		return errors.New("require_signature on an images listener needs domain")
+	}
+	for i, l := range cfg.listeners() {
+		if l.RequireSignature && len(cfg.Signing.Secrets) == 0 {
+			return fmt.Errorf("listener %d: require_signature needs signing.secrets", i)
+		}
+		// 图片需要签名时，所有会生成签名地址的路由组都必须鉴权，包括不要求签名的其他监听
+		if g := l.signingGroup(); (l.RequireSignature || signsImages) && g != "" && !l.Auth.enabled() {
+			return fmt.Errorf("listener %d: %s routes need auth when images require signatures", i, g)
+		}
+		if l.Filter != "" && cfg.Filters[l.Filter] == nil {
+			return fmt.Errorf("listener %d: unknown filter %q", i, l.Filter)
+		}
+		for _, name := range l.Auth.KeyFilters {
+			if cfg.Filters[name] == nil {
+				return fmt.Errorf("listener %d: unknown filter %q", i, name)
+			}
+		}
+	}
+	return nil
+}
+
+// bindKey 用于判断监听相关的配置是否变化
+func (l *ListenerConfig) bindKey() string {
+	return strings.Join([]string{l.Host, l.Port, l.Socket, l.TLSCert, l.TLSKey, l.RedirectAddr}, "|")
+}
+
+// Duration 支持在 json 中以 "10s" 形式书写的时间间隔
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+var (
+	configPath    string
+	configWatch   time.Duration
+	currentConfig atomic.Pointer[Config]
+	reloadMu      sync.Mutex
+)
+
+// conf 返回当前生效的配置，调用方不应修改返回值
+func conf() *Config {
+	return currentConfig.Load()
+}
+
+// loadConfig 按 启动参数 < 配置文件 < 环境变量 的优先级生成配置
+func loadConfig() (*Config, error) {
+	cfg := &Config{
+		ListenerConfig: ListenerConfig{
+			Host: host,
+			Port: port,
+		},
+		Domain:      domain,
+		Cookies:     cookies,
+		RewriteURLs: rewrite,
+
+		ReadHeaderTimeout: Duration(10 * time.Second),
+		IdleTimeout:       Duration(120 * time.Second),
+		ShutdownTimeout:   Duration(30 * time.Second),
+
+		PximgSizes: defaultPximgSizes,
+		Signing:    SigningConfig{TTL: Duration(24 * time.Hour)},
+
+		ImageHeaders:    defaultImageHeaders,
+		SecurityHeaders: map[string]string{},
+		CacheControl:    map[string]string{},
+		EmbedCrawlers:   defaultEmbedCrawlers,
+	}
+	for k, v := range defaultSecurityHeaders {
+		cfg.SecurityHeaders[k] = v
+	}
+	for k, v := range defaultCacheControl {
+		cfg.CacheControl[k] = v
+	}
+	if configPath != "" {
+		b, err := os.ReadFile(configPath)
+		if err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(b, cfg); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", configPath, err)
+		}
+	}
+	checkEnv(cfg)
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	if cfg.PlaceholderImage != "" {
+		b, err := os.ReadFile(cfg.PlaceholderImage)
+		if err != nil {
+			return nil, err
+		}
+		cfg.placeholder = b
+	}
+	cfg.client = pixiv.NewClient(client)
+	cfg.client.Cookies = cfg.Cookies
+	return cfg, nil
+}
+
+// checkLiveChange 检查无法在运行中生效的配置项
+func checkLiveChange(old, cfg *Config) error {
+	ol, nl := old.listeners(), cfg.listeners()
+	if len(ol) != len(nl) {
+		return fmt.Errorf("number of listeners changed (%d -> %d), restart required", len(ol), len(nl))
+	}
+	for i := range ol {
+		if ol[i].bindKey() != nl[i].bindKey() {
+			return fmt.Errorf("listener %d address or certificate path changed, restart required", i)
+		}
+	}
+	if old.ReadHeaderTimeout != cfg.ReadHeaderTimeout || old.IdleTimeout != cfg.IdleTimeout {
+		return fmt.Errorf("server timeouts changed, restart required")
+	}
+	return nil
+}
+
+func reloadConfig() error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Warn("reload config failed, keep old config: ", err)
+		return err
+	}
+	if err = checkLiveChange(conf(), cfg); err != nil {
+		log.Warn("reload config rejected, keep old config: ", err)
+		return err
+	}
+	currentConfig.Store(cfg)
+	log.Info("config reloaded")
+	return nil
+}
+
+// watchReload 监听 SIGHUP，并在开启 -watch 时轮询配置文件的修改时间
+func watchReload() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	var tick <-chan time.Time
+	var lastMod time.Time
+	if configPath != "" && configWatch > 0 {
+		if fi, err := os.Stat(configPath); err == nil {
+			lastMod = fi.ModTime()
+		}
+		tick = time.NewTicker(configWatch).C
+	}
+	for {
+		select {
+		case <-sig:
+			log.Info("SIGHUP received, reloading config")
+			_ = reloadConfig()
+		case <-tick:
+			fi, err := os.Stat(configPath)
+			if err != nil || fi.ModTime().Equal(lastMod) {
+				continue
+			}
+			lastMod = fi.ModTime()
+			log.Infof("%s changed, reloading config", configPath)
+			_ = reloadConfig()
+		}
+	}
+}
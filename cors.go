@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// CORSPolicy 跨域规则，按路由组配置
+type CORSPolicy struct {
+	// AllowedOrigins 允许的来源，如 https://app.example.com，支持 https://*.example.com 和 *
+	AllowedOrigins   []string `json:"allowed_origins"`
+	AllowedMethods   []string `json:"allowed_methods"`
+	AllowedHeaders   []string `json:"allowed_headers"`
+	AllowCredentials bool     `json:"allow_credentials"`
+	// MaxAge 预检结果的缓存秒数
+	MaxAge int `json:"max_age"`
+}
+
+func (p *CORSPolicy) allowOrigin(origin string) bool {
+	for _, o := range p.AllowedOrigins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+		if scheme, host, ok := strings.Cut(o, "://"); ok && strings.HasPrefix(host, "*.") {
+			if rest, ok := strings.CutPrefix(strings.ToLower(origin), strings.ToLower(scheme)+"://"); ok && matchDomain(host, rest) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p *CORSPolicy) methods() string {
+	if len(p.AllowedMethods) == 0 {
+		return "GET, HEAD"
+	}
+	return strings.Join(p.AllowedMethods, ", ")
+}
+
+func (p *CORSPolicy) headers() string {
+	if len(p.AllowedHeaders) == 0 {
+		return "Content-Type, Authorization, X-API-Key"
+	}
+	return strings.Join(p.AllowedHeaders, ", ")
+}
+
+// handleCORS 为跨域请求加上 Access-Control-* 头，预检请求直接在此应答并返回 false
+func handleCORS(cfg *Config, group string, rw http.ResponseWriter, req *http.Request) bool {
+	p := cfg.CORS[group]
+	if p == nil {
+		return true
+	}
+	origin := req.Header.Get("Origin")
+	rw.Header().Add("Vary", "Origin")
+	preflight := req.Method == http.MethodOptions && req.Header.Get("Access-Control-Request-Method") != ""
+	if origin == "" || !p.allowOrigin(origin) {
+		if preflight {
+			rw.WriteHeader(http.StatusForbidden)
+			return false
+		}
+		return true
+	}
+	h := rw.Header()
+	if in(p.AllowedOrigins, "*") && !p.AllowCredentials {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	if p.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if !preflight {
+		return true
+	}
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+	h.Set("Access-Control-Allow-Methods", p.methods())
+	h.Set("Access-Control-Allow-Headers", p.headers())
+	if p.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(p.MaxAge))
+	}
+	rw.WriteHeader(http.StatusNoContent)
+	return false
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"html"
+	"html/template"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"go-pixiv-proxy/pixiv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	//go:embed embed.html
+	embedHtml     string
+	embedTemplate = template.Must(template.New("embed").Parse(embedHtml))
+
+	// defaultEmbedCrawlers 生成链接预览的爬虫 User-Agent，访问 pid 图片时返回嵌入页
+	defaultEmbedCrawlers = []string{
+		"Discordbot", "TelegramBot", "Twitterbot", "facebookexternalhit", "Slackbot",
+		"WhatsApp", "LinkedInBot", "redditbot", "SkypeUriPreview", "Mastodon", "Misskey",
+	}
+
+	htmlTagRe = regexp.MustCompile(`<[^>]*>`)
+)
+
+const (
+	// embedMaxImages 多页作品最多输出的 og:image 数量
+	embedMaxImages = 4
+	// embedDescLen 描述截断的字符数
+	embedDescLen = 200
+)
+
+type embedImage struct {
+	URL           string
+	Width, Height int
+}
+
+type embedPage struct {
+	Title       string
+	Author      string
+	Description string
+	URL         string
+	Images      []embedImage
+	// OEmbed oEmbed 发现地址，不含 format 参数
+	OEmbed string
+}
+
+// baseURL 返回代理对外的地址，优先使用配置的 domain，否则由请求推断，
+// 此时不同的转发头会得到不同的响应，需要加上 Vary
+func (c *Context) baseURL() string {
+	if d := conf().Domain; d != "" {
+		return strings.TrimSuffix(d, "/")
+	}
+	addVary(c.rw.Header(), "X-Forwarded-Host", "X-Forwarded-Proto")
+	scheme := "http"
+	if c.req.TLS != nil {
+		scheme = "https"
+	}
+	if p := c.req.Header.Get("X-Forwarded-Proto"); p == "http" || p == "https" {
+		scheme = p
+	}
+	h := c.req.Host
+	if fh := c.req.Header.Get("X-Forwarded-Host"); fh != "" {
+		h, _, _ = strings.Cut(fh, ",")
+		h = strings.TrimSpace(h)
+	}
+	return scheme + "://" + h
+}
+
+// proxyURL 返回代理路径的完整地址。地址指向提供图片的监听，它要求签名时附带签名参数，
+// 与当前请求所在的监听无关。只为在当前监听上通过 auth 鉴权的请求签名，匿名请求得到的是未签名的地址，
+// 否则嵌入页、订阅和 api 都可以被用来获取任意图片的有效签名
+func (c *Context) proxyURL(path string) string {
+	if p := c.policy(); p != nil && p.authenticated && conf().signsImages() {
+		signed, err := signURL(&conf().Signing, path, 0)
+		if err != nil {
+			log.Error("sign url: ", err)
+		} else {
+			path = signed
+		}
+	}
+	return c.baseURL() + path
+}
+
+// wantsEmbed 判断是否为需要嵌入页的爬虫请求：User-Agent 匹配且接受 text/html
+func wantsEmbed(req *http.Request, crawlers []string) bool {
+	ua := strings.ToLower(req.UserAgent())
+	matched := false
+	for _, s := range crawlers {
+		if s != "" && strings.Contains(ua, strings.ToLower(s)) {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return false
+	}
+	accept := req.Header.Get("Accept")
+	return accept == "" || strings.Contains(accept, "text/html") || strings.Contains(accept, "*/*")
+}
+
+// plainDescription 去掉 pixiv 描述中的 html 标签并截断
+func plainDescription(s string) string {
+	s = strings.NewReplacer("<br />", "\n", "<br/>", "\n", "<br>", "\n").Replace(s)
+	s = strings.TrimSpace(html.UnescapeString(htmlTagRe.ReplaceAllString(s, "")))
+	if r := []rune(s); len(r) > embedDescLen {
+		s = string(r[:embedDescLen]) + "…"
+	}
+	return s
+}
+
+// regularSize 按 regular（master1200）的缩放规则计算尺寸
+func regularSize(w, h int) (int, int) {
+	if w <= 1200 && h <= 1200 || w <= 0 || h <= 0 {
+		return w, h
+	}
+	if w >= h {
+		return 1200, h * 1200 / w
+	}
+	return w * 1200 / h, 1200
+}
+
+func newEmbedPage(c *Context, il *pixiv.Illust, start int) *embedPage {
+	ret := &embedPage{
+		Title:  il.Title,
+		Author: il.UserName,
+		URL:    "https://www.pixiv.net/artworks/" + il.ID,
+	}
+	desc := []string{"by " + il.UserName}
+	if len(il.Tags.Tags) > 0 {
+		tags := make([]string, 0, len(il.Tags.Tags))
+		for _, t := range il.Tags.Tags {
+			tags = append(tags, "#"+t.Tag)
+		}
+		desc = append(desc, strings.Join(tags, " "))
+	}
+	if d := plainDescription(il.Description); d != "" {
+		desc = append(desc, d)
+	}
+	ret.Description = strings.Join(desc, "\n")
+	pages := il.PageCount
+	if pages < 1 {
+		pages = 1
+	}
+	for i := start; i < pages && len(ret.Images) < embedMaxImages; i++ {
+		img := embedImage{URL: c.proxyURL("/" + il.ID + "/" + strconv.Itoa(i) + "?t=regular")}
+		if i == 0 {
+			img.URL = c.proxyURL("/" + il.ID + "?t=regular")
+			// 只有第一页的尺寸是已知的
+			img.Width, img.Height = regularSize(il.Width, il.Height)
+		}
+		ret.Images = append(ret.Images, img)
+	}
+	return ret
+}
+
+// renderEmbed 输出带 OpenGraph / Twitter Card 标签的作品页面
+func renderEmbed(c *Context) {
+	// 嵌入页内容会变化，不使用图片的长期缓存
+	c.cache = cacheAPI
+	illust, err := pixivClient().Illust(c.req.Context(), c.Param("pid"))
+	if err != nil {
+		c.PixivError(err)
+		return
+	}
+	start, _ := strconv.Atoi(c.Param("page"))
+	var page *embedPage
+	if c.blockedWork(illust) {
+		if !c.filter().Placeholder || conf().placeholder == nil {
+			c.Error(http.StatusUnavailableForLegalReasons, "this work is filtered")
+			return
+		}
+		page = &embedPage{
+			Title:  "filtered",
+			URL:    "https://www.pixiv.net/artworks/" + illust.ID,
+			Images: []embedImage{{URL: c.proxyURL("/_placeholder")}},
+		}
+	} else {
+		page = newEmbedPage(c, illust, start)
+		self := c.baseURL() + "/i/" + illust.ID
+		if start > 0 {
+			self += "/" + strconv.Itoa(start)
+		}
+		page.OEmbed = c.baseURL() + "/oembed?url=" + url.QueryEscape(self)
+	}
+	var buf bytes.Buffer
+	if err = embedTemplate.Execute(&buf, page); err != nil {
+		c.Error(500, err.Error())
+		return
+	}
+	c.rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	c.writeCacheable(buf.Bytes())
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"hash/crc32"
+	"html"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// epubBook 边生成边输出的 EPUB 3，图片和各页在加入时立即写出，
+// content.opf 和目录在 close 时最后写出，因此不需要把整本书保存在内存中
+type epubBook struct {
+	Identifier  string
+	Title       string
+	Author      string
+	Language    string
+	Description string
+	Modified    time.Time
+	// FixedLayout 固定版式，用于每页为一张图片的漫画
+	FixedLayout bool
+	// RTL 从右向左翻页
+	RTL bool
+
+	w  io.Writer
+	zw *zip.Writer
+	// onStart 在写出第一个字节之前调用，用于设置响应头
+	onStart  func()
+	cover    string
+	sections []epubSection
+	images   []epubImage
+}
+
+type epubSection struct {
+	// Name 文件名，不含扩展名
+	Name  string
+	Title string
+	// Body <body> 中的 xhtml
+	Body string
+	// Hidden 不在目录中列出
+	Hidden bool
+	// Width、Height 固定版式页面的尺寸
+	Width, Height int
+}
+
+type epubImage struct {
+	Name      string
+	MediaType string
+	Data      []byte
+}
+
+const epubContainer = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+<rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles>
+</container>
+`
+
+const epubStyle = `body { margin: 0 1em; line-height: 1.8; }
+p { margin: 0; }
+p.image { text-align: center; margin: 1em 0; }
+img { max-width: 100%; max-height: 100vh; }
+body.fixed { margin: 0; padding: 0; }
+img.page { display: block; width: 100%; height: 100%; max-width: none; max-height: none; }
+`
+
+func newEpubBook(w io.Writer) *epubBook {
+	return &epubBook{w: w}
+}
+
+func parseDate(s string) time.Time {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+func (b *epubBook) lang() string {
+	if b.Language == "" {
+		return "ja"
+	}
+	return html.EscapeString(b.Language)
+}
+
+func (b *epubBook) xhtml(sec *epubSection, style string) string {
+	head := `<meta charset="utf-8"/><title>` + html.EscapeString(sec.Title) + `</title><link rel="stylesheet" href="` + style + `"/>`
+	body := "<body>"
+	if sec.Width > 0 {
+		head += `<meta name="viewport" content="width=` + strconv.Itoa(sec.Width) + `, height=` + strconv.Itoa(sec.Height) + `"/>`
+		body = `<body class="fixed">`
+	}
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" xml:lang="` + b.lang() + `">
+<head>` + head + `</head>
+` + body + "\n" + sec.Body + `</body>
+</html>
+`
+}
+
+// started 是否已开始输出，开始后出错只能中断连接
+func (b *epubBook) started() bool {
+	return b.zw != nil
+}
+
+func (b *epubBook) add(name string, data []byte, method uint16) error {
+	if b.zw == nil {
+		if b.Modified.IsZero() {
+			b.Modified = time.Now()
+		}
+		if b.onStart != nil {
+			b.onStart()
+		}
+		b.zw = zip.NewWriter(b.w)
+		if err := b.addMimetype(); err != nil {
+			return err
+		}
+		if err := b.add("META-INF/container.xml", []byte(epubContainer), zip.Deflate); err != nil {
+			return err
+		}
+	}
+	f, err := b.zw.CreateHeader(&zip.FileHeader{Name: name, Method: method, Modified: b.Modified})
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	return err
+}
+
+// addMimetype 写出 mimetype，它必须是第一个不压缩的文件，且不能有扩展字段和数据描述符，
+// 因此预先计算 CRC 和大小，用 CreateRaw 写出
+func (b *epubBook) addMimetype() error {
+	data := []byte("application/epub+zip")
+	f, err := b.zw.CreateRaw(&zip.FileHeader{
+		Name:               "mimetype",
+		Method:             zip.Store,
+		CRC32:              crc32.ChecksumIEEE(data),
+		CompressedSize64:   uint64(len(data)),
+		UncompressedSize64: uint64(len(data)),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	return err
+}
+
+// addImage 写出图片，图片本身已压缩，不再压缩
+func (b *epubBook) addImage(img *epubImage) error {
+	if err := b.add("OEBPS/images/"+img.Name, img.Data, zip.Store); err != nil {
+		return err
+	}
+	b.images = append(b.images, epubImage{Name: img.Name, MediaType: img.MediaType})
+	return nil
+}
+
+// addCover 写出封面图片，非固定版式时同时加入封面页
+func (b *epubBook) addCover(img *epubImage) error {
+	if err := b.addImage(img); err != nil {
+		return err
+	}
+	b.cover = img.Name
+	if b.FixedLayout {
+		return nil
+	}
+	return b.addSection(epubSection{
+		Name:   "cover",
+		Title:  b.Title,
+		Body:   `<p class="image"><img src="../images/` + img.Name + `" alt=""/></p>` + "\n",
+		Hidden: true,
+	})
+}
+
+func (b *epubBook) addSection(sec epubSection) error {
+	if err := b.add("OEBPS/text/"+sec.Name+".xhtml", []byte(b.xhtml(&sec, "../style.css")), zip.Deflate); err != nil {
+		return err
+	}
+	sec.Body = ""
+	b.sections = append(b.sections, sec)
+	return nil
+}
+
+func (b *epubBook) opf() string {
+	var s strings.Builder
+	s.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="id" xml:lang="` + b.lang() + `">
+<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+<dc:identifier id="id">` + html.EscapeString(b.Identifier) + `</dc:identifier>
+<dc:title>` + html.EscapeString(b.Title) + `</dc:title>
+<dc:creator>` + html.EscapeString(b.Author) + `</dc:creator>
+<dc:language>` + b.lang() + `</dc:language>
+`)
+	if b.Description != "" {
+		s.WriteString(`<dc:description>` + html.EscapeString(b.Description) + "</dc:description>\n")
+	}
+	s.WriteString(`<meta property="dcterms:modified">` + b.Modified.UTC().Format("2006-01-02T15:04:05Z") + "</meta>\n")
+	if b.FixedLayout {
+		s.WriteString(`<meta property="rendition:layout">pre-paginated</meta>` + "\n")
+		s.WriteString(`<meta property="rendition:spread">landscape</meta>` + "\n")
+	}
+	s.WriteString("</metadata>\n<manifest>\n")
+	s.WriteString(`<item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>` + "\n")
+	s.WriteString(`<item id="style" href="style.css" media-type="text/css"/>` + "\n")
+	for i, sec := range b.sections {
+		s.WriteString(`<item id="s` + strconv.Itoa(i) + `" href="text/` + sec.Name + `.xhtml" media-type="application/xhtml+xml"/>` + "\n")
+	}
+	for i, img := range b.images {
+		props := ""
+		if img.Name == b.cover {
+			props = ` properties="cover-image"`
+		}
+		s.WriteString(`<item id="i` + strconv.Itoa(i) + `" href="images/` + img.Name + `" media-type="` + img.MediaType + `"` + props + "/>\n")
+	}
+	s.WriteString("</manifest>\n")
+	if b.RTL {
+		s.WriteString(`<spine page-progression-direction="rtl">` + "\n")
+	} else {
+		s.WriteString("<spine>\n")
+	}
+	for i := range b.sections {
+		s.WriteString(`<itemref idref="s` + strconv.Itoa(i) + `"/>` + "\n")
+	}
+	s.WriteString("</spine>\n</package>\n")
+	return s.String()
+}
+
+func (b *epubBook) nav() string {
+	var s strings.Builder
+	s.WriteString(`<nav epub:type="toc" id="toc"><h1>` + html.EscapeString(b.Title) + "</h1>\n<ol>\n")
+	for _, sec := range b.sections {
+		if !sec.Hidden {
+			s.WriteString(`<li><a href="text/` + sec.Name + `.xhtml">` + html.EscapeString(sec.Title) + "</a></li>\n")
+		}
+	}
+	s.WriteString("</ol>\n</nav>\n")
+	return b.xhtml(&epubSection{Title: b.Title, Body: s.String()}, "style.css")
+}
+
+// close 写出样式、content.opf 和目录并结束压缩包
+func (b *epubBook) close() error {
+	files := []struct {
+		name string
+		data string
+	}{
+		{"OEBPS/style.css", epubStyle},
+		{"OEBPS/content.opf", b.opf()},
+		{"OEBPS/nav.xhtml", b.nav()},
+	}
+	for _, f := range files {
+		if err := b.add(f.name, []byte(f.data), zip.Deflate); err != nil {
+			return err
+		}
+	}
+	return b.zw.Close()
+}
+
+// imageSize 读取图片尺寸，用于固定版式的页面
+func imageSize(data []byte) (int, int) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0
+	}
+	return cfg.Width, cfg.Height
+}
+
+// streamEpub 以附件形式边生成边输出 EPUB，文件名为 name.epub。
+// 开始输出前出错时返回错误响应，开始输出后出错只能中断连接
+func (c *Context) streamEpub(name string, build func(b *epubBook) error) {
+	b := newEpubBook(c.rw)
+	b.onStart = func() {
+		h := c.rw.Header()
+		h.Set("Content-Type", "application/epub+zip")
+		h.Set("Content-Disposition", `attachment; filename="book.epub"; filename*=UTF-8''`+url.PathEscape(name+".epub"))
+		if cc := c.cacheControl(); cc != "" {
+			h.Set("Cache-Control", cc)
+		} else {
+			h.Set("Cache-Control", "no-cache")
+		}
+		c.rw.WriteHeader(http.StatusOK)
+	}
+	err := build(b)
+	if err == nil {
+		err = b.close()
+	}
+	if err == nil {
+		return
+	}
+	if !b.started() {
+		c.PixivError(err)
+		return
+	}
+	log.Errorf("epub %s: %v", name, err)
+	panic(http.ErrAbortHandler)
+}
+
+// handleApiEpub 生成 EPUB，type 为 novel（小说）、novel_series（小说系列）或 series（漫画系列）
+func handleApiEpub(c *Context) {
+	query := c.req.URL.Query()
+	id := query.Get("id")
+	if !isDigits(id) {
+		c.Error(400, "id invalid")
+		return
+	}
+	ctx := c.req.Context()
+	switch query.Get("type") {
+	case "novel":
+		n, err := pixivClient().Novel(ctx, id)
+		if err != nil {
+			c.PixivError(err)
+			return
+		}
+		if c.filter().blocked(n.XRestrict, n.AiType, novelTagNames(n)) {
+			c.Error(http.StatusUnavailableForLegalReasons, "this work is filtered")
+			return
+		}
+		c.streamEpub(n.Title, novelEpub(ctx, n))
+	case "novel_series":
+		series, err := pixivClient().NovelSeries(ctx, id)
+		if err != nil {
+			c.PixivError(err)
+			return
+		}
+		if c.filter().blocked(series.XRestrict, series.AiType, series.Tags) {
+			c.Error(http.StatusUnavailableForLegalReasons, "this work is filtered")
+			return
+		}
+		c.streamEpub(series.Title, c.novelSeriesEpub(series))
+	case "series":
+		first, err := pixivClient().Series(ctx, id, 1)
+		if err != nil {
+			c.PixivError(err)
+			return
+		}
+		info := first.Info(id)
+		if info == nil {
+			c.Error(404, "series not found")
+			return
+		}
+		works, err := seriesWorks(ctx, id, first)
+		if err != nil {
+			c.PixivError(err)
+			return
+		}
+		c.streamEpub(info.Title, c.mangaSeriesEpub(info, works, query.Get("quality") == "original"))
+	default:
+		c.Error(400, "type invalid")
+	}
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"html"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// feed 与输出格式无关的订阅内容
+type feed struct {
+	Title       string
+	Link        string
+	Description string
+	Items       []feedItem
+	// Chronological 条目按发布时间排列，可以用最新条目的时间作为 Last-Modified。
+	// 排行和搜索的结果在没有新作品时也会变化，只能依靠 ETag
+	Chronological bool
+}
+
+type feedItem struct {
+	ID        int64
+	Title     string
+	Link      string
+	Author    string
+	AuthorURL string
+	Tags      []string
+	Image     string
+	ImageType string
+	Published time.Time
+}
+
+// content 条目正文：图片和标签
+func (it *feedItem) content() string {
+	var b strings.Builder
+	b.WriteString(`<p><img src="` + html.EscapeString(it.Image) + `" alt="` + html.EscapeString(it.Title) + `"></p>`)
+	if len(it.Tags) > 0 {
+		b.WriteString("<p>")
+		for i, t := range it.Tags {
+			if i > 0 {
+				b.WriteString(" ")
+			}
+			b.WriteString("#" + html.EscapeString(t))
+		}
+		b.WriteString("</p>")
+	}
+	return b.String()
+}
+
+// updated 最新条目的发布时间，没有条目时为零值
+func (f *feed) updated() time.Time {
+	var t time.Time
+	for _, it := range f.Items {
+		if it.Published.After(t) {
+			t = it.Published
+		}
+	}
+	return t
+}
+
+// imageType 按 pixiv 图片地址的扩展名推断类型，regular 尺寸通常为 jpg
+func imageType(u string) string {
+	if pu, err := url.Parse(u); err == nil {
+		if t := mime.TypeByExtension(path.Ext(pu.Path)); strings.HasPrefix(t, "image/") {
+			return t
+		}
+	}
+	return "image/jpeg"
+}
+
+func newFeed(c *Context, title, link string, list *IllustList) *feed {
+	f := &feed{Title: title, Link: link, Description: title}
+	for _, il := range list.Illusts {
+		id := strconv.FormatInt(il.ID, 10)
+		it := feedItem{
+			ID:        il.ID,
+			Title:     il.Title,
+			Link:      "https://www.pixiv.net/artworks/" + id,
+			Author:    il.User.Name,
+			AuthorURL: "https://www.pixiv.net/users/" + strconv.FormatInt(il.User.ID, 10),
+			Image:     c.proxyURL("/" + id + "?t=regular"),
+			ImageType: imageType(il.ImageURLs.Large),
+		}
+		if il.Filtered {
+			it.Title, it.Author = "filtered", ""
+			it.Image = c.proxyURL("/_placeholder")
+			if img := conf().placeholder; img != nil {
+				it.ImageType = http.DetectContentType(img)
+			}
+		}
+		for _, t := range il.Tags {
+			it.Tags = append(it.Tags, t.Tag)
+		}
+		it.Published, _ = time.Parse(time.RFC3339, il.CreateDate)
+		f.Items = append(f.Items, it)
+	}
+	return f
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	DC      string     `xml:"xmlns:dc,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string       `xml:"title"`
+	Link        string       `xml:"link"`
+	GUID        string       `xml:"guid"`
+	Creator     string       `xml:"dc:creator,omitempty"`
+	Categories  []string     `xml:"category"`
+	PubDate     string       `xml:"pubDate,omitempty"`
+	Description string       `xml:"description"`
+	Enclosure   rssEnclosure `xml:"enclosure"`
+}
+
+// rssEnclosure 图片由代理按需获取，无法预先知道大小，不输出 length
+type rssEnclosure struct {
+	URL  string `xml:"url,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Href string `xml:"href,attr"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title      string         `xml:"title"`
+	ID         string         `xml:"id"`
+	Updated    string         `xml:"updated"`
+	Published  string         `xml:"published,omitempty"`
+	Links      []atomLink     `xml:"link"`
+	Author     *atomPerson    `xml:"author,omitempty"`
+	Categories []atomCategory `xml:"category"`
+	Content    atomContent    `xml:"content"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url"`
+	FeedURL     string         `json:"feed_url"`
+	Description string         `json:"description,omitempty"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonFeedItem struct {
+	ID            string           `json:"id"`
+	URL           string           `json:"url"`
+	Title         string           `json:"title"`
+	ContentHTML   string           `json:"content_html"`
+	Image         string           `json:"image"`
+	DatePublished string           `json:"date_published,omitempty"`
+	Authors       []jsonFeedAuthor `json:"authors,omitempty"`
+	Tags          []string         `json:"tags,omitempty"`
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+	URL  string `json:"url,omitempty"`
+}
+
+func formatTime(t time.Time, layout string) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(layout)
+}
+
+func (f *feed) rss() ([]byte, error) {
+	ch := rssChannel{
+		Title:         f.Title,
+		Link:          f.Link,
+		Description:   f.Description,
+		LastBuildDate: formatTime(f.updated(), time.RFC1123Z),
+		Items:         []rssItem{},
+	}
+	for i := range f.Items {
+		it := &f.Items[i]
+		ch.Items = append(ch.Items, rssItem{
+			Title:       it.Title,
+			Link:        it.Link,
+			GUID:        it.Link,
+			Creator:     it.Author,
+			Categories:  it.Tags,
+			PubDate:     formatTime(it.Published, time.RFC1123Z),
+			Description: it.content(),
+			Enclosure:   rssEnclosure{URL: it.Image, Type: it.ImageType},
+		})
+	}
+	b, err := xml.Marshal(rssFeed{Version: "2.0", DC: "http://purl.org/dc/elements/1.1/", Channel: ch})
+	return append([]byte(xml.Header), b...), err
+}
+
+func (f *feed) atom(self string) ([]byte, error) {
+	updated := f.updated()
+	if updated.IsZero() {
+		updated = time.Now()
+	}
+	af := atomFeed{
+		Title:   f.Title,
+		ID:      self,
+		Updated: formatTime(updated, time.RFC3339),
+		Links:   []atomLink{{Rel: "self", Href: self}, {Rel: "alternate", Href: f.Link}},
+	}
+	for i := range f.Items {
+		it := &f.Items[i]
+		e := atomEntry{
+			Title:     it.Title,
+			ID:        it.Link,
+			Updated:   formatTime(updated, time.RFC3339),
+			Published: formatTime(it.Published, time.RFC3339),
+			Links:     []atomLink{{Rel: "alternate", Href: it.Link}, {Rel: "enclosure", Href: it.Image, Type: it.ImageType}},
+			Content:   atomContent{Type: "html", Body: it.content()},
+		}
+		if e.Published != "" {
+			e.Updated = e.Published
+		}
+		if it.Author != "" {
+			e.Author = &atomPerson{Name: it.Author, URI: it.AuthorURL}
+		}
+		for _, t := range it.Tags {
+			e.Categories = append(e.Categories, atomCategory{Term: t})
+		}
+		af.Entries = append(af.Entries, e)
+	}
+	b, err := xml.Marshal(af)
+	return append([]byte(xml.Header), b...), err
+}
+
+func (f *feed) json(self string) ([]byte, error) {
+	jf := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       f.Title,
+		HomePageURL: f.Link,
+		FeedURL:     self,
+		Description: f.Description,
+		Items:       []jsonFeedItem{},
+	}
+	for i := range f.Items {
+		it := &f.Items[i]
+		item := jsonFeedItem{
+			ID:            strconv.FormatInt(it.ID, 10),
+			URL:           it.Link,
+			Title:         it.Title,
+			ContentHTML:   it.content(),
+			Image:         it.Image,
+			DatePublished: formatTime(it.Published, time.RFC3339),
+			Tags:          it.Tags,
+		}
+		if it.Author != "" {
+			item.Authors = []jsonFeedAuthor{{Name: it.Author, URL: it.AuthorURL}}
+		}
+		jf.Items = append(jf.Items, item)
+	}
+	return json.Marshal(jf)
+}
+
+// writeFeed 按 format 参数（rss、atom、json）输出，按时间排列的订阅以最新条目的时间作为 Last-Modified
+func (c *Context) writeFeed(f *feed) {
+	self := c.baseURL() + c.req.URL.RequestURI()
+	var (
+		b   []byte
+		err error
+	)
+	switch c.req.URL.Query().Get("format") {
+	case "", "rss":
+		b, err = f.rss()
+		c.rw.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	case "atom":
+		b, err = f.atom(self)
+		c.rw.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	case "json":
+		b, err = f.json(self)
+		c.rw.Header().Set("Content-Type", "application/feed+json; charset=utf-8")
+	default:
+		c.Error(400, "format invalid")
+		return
+	}
+	if err != nil {
+		c.Error(500, err.Error())
+		return
+	}
+	if t := f.updated(); f.Chronological && !t.IsZero() {
+		c.rw.Header().Set("Last-Modified", t.UTC().Format(http.TimeFormat))
+		// If-None-Match 优先，由 writeCacheable 处理
+		if c.req.Header.Get("If-None-Match") == "" {
+			if since, err := http.ParseTime(c.req.Header.Get("If-Modified-Since")); err == nil && !t.Truncate(time.Second).After(since) {
+				c.rw.Header().Del("Content-Type")
+				c.rw.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+	}
+	c.writeCacheable(b)
+}
+
+func handleFeedRank(c *Context) {
+	opt, page, err := parseRankingQuery(c.req.URL.Query())
+	if err != nil {
+		c.Error(400, "page invalid")
+		return
+	}
+	ranking, err := pixivClient().Ranking(c.req.Context(), opt)
+	if err != nil {
+		c.PixivError(err)
+		return
+	}
+	list := GetRankingResults(ranking, page)
+	c.filterList(list)
+	title := "pixiv ranking " + ranking.Mode
+	if date, err := time.Parse("20060102", ranking.Date); err == nil {
+		title += " " + date.Format(time.DateOnly)
+	}
+	c.writeFeed(newFeed(c, title, "https://www.pixiv.net/ranking.php?mode="+ranking.Mode, list))
+}
+
+func handleFeedSearch(c *Context) {
+	query := c.req.URL.Query()
+	word := query.Get("word")
+	if word == "" {
+		c.Error(400, "word invalid")
+		return
+	}
+	page := 0.0
+	if p, err := strconv.Atoi(query.Get("page")); err == nil {
+		page = float64(p)
+	}
+	targetPage, _ := strconv.Atoi(getTargetPage(page))
+	res, err := pixivClient().Search(c.req.Context(), word, targetPage)
+	if err != nil {
+		c.PixivError(err)
+		return
+	}
+	list := GetSearchResults(res, page)
+	c.filterList(list)
+	c.writeFeed(newFeed(c, "pixiv search "+word, "https://www.pixiv.net/tags/"+url.PathEscape(word)+"/artworks", list))
+}
+
+func handleFeedUser(c *Context) {
+	uid := c.Param("uid")
+	list, err := GetMemberIllusts(c.req.Context(), uid, 1, "")
+	if err != nil {
+		c.PixivError(err)
+		return
+	}
+	c.filterList(list)
+	title := "pixiv user " + uid
+	if list.User != nil && list.User.Name != "" {
+		title = "pixiv user " + list.User.Name
+	} else if len(list.Illusts) > 0 && list.Illusts[0].User.Name != "" {
+		title = "pixiv user " + list.Illusts[0].User.Name
+	}
+	f := newFeed(c, title, "https://www.pixiv.net/users/"+uid, list)
+	f.Chronological = true
+	c.writeFeed(f)
+}
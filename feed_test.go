@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+func TestImageType(t *testing.T) {
+	tests := map[string]string{
+		"https://i.pximg.net/img-master/img/2022/05/01/00/00/00/98505703_p0_master1200.jpg": "image/jpeg",
+		"https://i.pximg.net/img-original/img/2022/05/01/00/00/00/98505703_p0.png":          "image/png",
+		"https://i.pximg.net/img-original/img/2022/05/01/00/00/00/98505703_p0.gif?x=1.png":  "image/gif",
+		"/img-master/img/2022/05/01/00/00/00/98505703_p0_master1200.jpg":                    "image/jpeg",
+		"":                                  "image/jpeg",
+		"https://example.com/98505703":      "image/jpeg",
+		"https://example.com/98505703.html": "image/jpeg",
+	}
+	for u, want := range tests {
+		if got := imageType(u); got != want {
+			t.Errorf("%q: got %q, want %q", u, got, want)
+		}
+	}
+}
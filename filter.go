@@ -0,0 +1,153 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"go-pixiv-proxy/pixiv"
+)
+
+// FilterPolicy 内容过滤策略，在配置的 filters 中按名称定义，由监听或 api key 选择
+type FilterPolicy struct {
+	BlockR18  bool     `json:"block_r18"`
+	BlockR18G bool     `json:"block_r18g"`
+	BlockAI   bool     `json:"block_ai"`
+	BlockTags []string `json:"block_tags"`
+	// Placeholder 为 true 时列表中被过滤的作品替换为占位项、图片返回占位图，
+	// 否则从列表中移除、图片返回 451
+	Placeholder bool `json:"placeholder"`
+}
+
+// pixiv 的 aiType：0 未设置，1 非 AI，2 AI 生成
+const aiTypeGenerated = 2
+
+// filter 返回当前请求适用的过滤策略，没有时返回 nil
+func (c *Context) filter() *FilterPolicy {
+	if p := c.policy(); p != nil {
+		return p.filter
+	}
+	return nil
+}
+
+// blocked 判断作品是否应被过滤，p 为 nil 时不过滤
+func (p *FilterPolicy) blocked(xRestrict, aiType int, tags []string) bool {
+	if p == nil {
+		return false
+	}
+	if (p.BlockR18 && xRestrict == 1) || ((p.BlockR18G || p.BlockR18) && xRestrict == 2) {
+		return true
+	}
+	if p.BlockAI && aiType == aiTypeGenerated {
+		return true
+	}
+	for _, t := range tags {
+		for _, b := range p.BlockTags {
+			if strings.EqualFold(t, b) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p *FilterPolicy) blockedIllust(il *Illust) bool {
+	tags := make([]string, 0, len(il.Tags))
+	for _, t := range il.Tags {
+		tags = append(tags, t.Tag)
+	}
+	return p.blocked(il.XRestrict, il.IllustAIType, tags)
+}
+
+// blockedWork 判断当前请求是否应过滤该作品
+func (c *Context) blockedWork(il *pixiv.Illust) bool {
+	p := c.filter()
+	if p == nil {
+		return false
+	}
+	tags := make([]string, 0, len(il.Tags.Tags))
+	for _, t := range il.Tags.Tags {
+		tags = append(tags, t.Tag)
+	}
+	return p.blocked(il.XRestrict, il.AiType, tags)
+}
+
+// filterList 移除或替换列表中被过滤的作品
+func (c *Context) filterList(list *IllustList) {
+	p := c.filter()
+	if p == nil {
+		return
+	}
+	illusts := list.Illusts[:0]
+	for i := range list.Illusts {
+		il := list.Illusts[i]
+		if !p.blockedIllust(&il) {
+			illusts = append(illusts, il)
+			continue
+		}
+		if p.Placeholder {
+			illusts = append(illusts, c.placeholderIllust(il.ID))
+		}
+	}
+	list.Illusts = illusts
+	list.Length = len(illusts)
+}
+
+// filterNovels 移除或替换小说列表中被过滤的作品
+func (c *Context) filterNovels(list *NovelList) {
+	p := c.filter()
+	if p == nil {
+		return
+	}
+	novels := list.Novels[:0]
+	for i := range list.Novels {
+		n := list.Novels[i]
+		tags := make([]string, 0, len(n.Tags))
+		for _, t := range n.Tags {
+			tags = append(tags, t.Tag)
+		}
+		if !p.blocked(n.XRestrict, n.NovelAIType, tags) {
+			novels = append(novels, n)
+			continue
+		}
+		if p.Placeholder {
+			u := c.proxyURL("/_placeholder")
+			novels = append(novels, Novel{ID: n.ID, Tags: []Tag{}, ImageURLs: ImageURLs{Medium: u}, Filtered: true})
+		}
+	}
+	list.Novels = novels
+	list.Length = len(novels)
+}
+
+// placeholderIllust 被过滤的作品，图片为占位图的代理地址
+func (c *Context) placeholderIllust(id int64) Illust {
+	u := c.proxyURL("/_placeholder")
+	return Illust{
+		ID:             id,
+		Tags:           []Tag{},
+		ImageURLs:      ImageURLs{SquareMedium: u, Medium: u, Large: u},
+		MetaSinglePage: MetaSinglePage{OriginalImageURL: u},
+		MetaPages:      []MetaPage{},
+		Filtered:       true,
+	}
+}
+
+// rejectFiltered 图片被过滤时返回占位图或 451
+func (c *Context) rejectFiltered() {
+	if c.filter().Placeholder && conf().placeholder != nil {
+		handlePlaceholder(c)
+		return
+	}
+	c.Error(http.StatusUnavailableForLegalReasons, "this work is filtered")
+}
+
+func handlePlaceholder(c *Context) {
+	img := conf().placeholder
+	if img == nil {
+		c.Error(404, "placeholder image not configured")
+		return
+	}
+	// 占位图随配置变化，不能沿用图片路由的长期缓存
+	c.rw.Header().Set("Content-Type", http.DetectContentType(img))
+	c.rw.Header().Set("Cache-Control", "no-cache")
+	c.writeCacheable(img)
+}
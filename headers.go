@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+var (
+	// defaultImageHeaders 转发图片时保留的上游响应头
+	defaultImageHeaders = []string{"Content-Type", "Content-Length", "Last-Modified", "ETag", "Cache-Control"}
+
+	defaultSecurityHeaders = map[string]string{
+		"X-Content-Type-Options": "nosniff",
+		"Referrer-Policy":        "strict-origin-when-cross-origin",
+		"X-Frame-Options":        "SAMEORIGIN",
+	}
+)
+
+// neverForward 无论配置如何都不会转发给客户端的上游响应头
+func neverForward(k string) bool {
+	k = http.CanonicalHeaderKey(k)
+	return k == "Set-Cookie" || k == "Set-Cookie2" || k == "Cookie" ||
+		strings.HasPrefix(k, "Access-Control-") || k == "Strict-Transport-Security" ||
+		strings.HasPrefix(k, "Cf-") || strings.HasPrefix(k, "X-Userid")
+}
+
+// copyAllowedHeaders 只复制 allow 中列出的响应头
+func copyAllowedHeaders(dst, src http.Header, allow []string) {
+	for _, k := range allow {
+		if neverForward(k) {
+			continue
+		}
+		if vv := src.Values(k); len(vv) > 0 {
+			dst[http.CanonicalHeaderKey(k)] = append([]string(nil), vv...)
+		}
+	}
+}
+
+// setSecurityHeaders 为所有响应加上配置的安全相关响应头，值为空表示不设置
+func setSecurityHeaders(h http.Header, headers map[string]string) {
+	for k, v := range headers {
+		if v != "" {
+			h.Set(k, v)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// HotlinkPolicy 防盗链规则，按路由组配置
+type HotlinkPolicy struct {
+	// Allow 允许的 Referer / Origin 域名，支持 *.example.com 匹配子域名，* 匹配全部
+	Allow []string `json:"allow"`
+	// AllowEmpty 允许没有 Referer 和 Origin 的请求（直接访问、部分 app）
+	AllowEmpty bool `json:"allow_empty"`
+	// Placeholder 为 true 且配置了 placeholder_image 时返回占位图，否则返回 403
+	Placeholder bool `json:"placeholder"`
+}
+
+// requestSite 取出 Origin，没有时取 Referer 的域名（不含端口）
+func requestSite(req *http.Request) string {
+	raw := req.Header.Get("Origin")
+	if raw == "" || raw == "null" {
+		raw = req.Referer()
+	}
+	if raw == "" {
+		return ""
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		// 无法解析的 Referer 视为不匹配任何规则
+		return "invalid"
+	}
+	h := u.Host
+	if sh, _, err := net.SplitHostPort(h); err == nil {
+		h = sh
+	}
+	return strings.ToLower(h)
+}
+
+func matchDomain(pattern, host string) bool {
+	pattern = strings.ToLower(pattern)
+	if pattern == "*" {
+		return true
+	}
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		return strings.HasSuffix(host, "."+suffix)
+	}
+	return host == pattern
+}
+
+func (p *HotlinkPolicy) allowed(req *http.Request) bool {
+	site := requestSite(req)
+	if site == "" {
+		return p.AllowEmpty
+	}
+	for _, a := range p.Allow {
+		if matchDomain(a, site) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkHotlink 按路由组的防盗链规则检查请求，拦截时写出响应并返回 false
+func checkHotlink(cfg *Config, group string, rw http.ResponseWriter, req *http.Request) bool {
+	p := cfg.Hotlink[group]
+	if p == nil || p.allowed(req) {
+		return true
+	}
+	if p.Placeholder && cfg.placeholder != nil {
+		rw.Header().Set("Content-Type", http.DetectContentType(cfg.placeholder))
+		rw.Header().Set("Cache-Control", "no-store")
+		_, _ = rw.Write(cfg.placeholder)
+		return false
+	}
+	(&Context{rw: rw, req: req}).Error(http.StatusForbidden, "hotlinking is not allowed")
+	return false
+}
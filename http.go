@@ -1,24 +1,22 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"io"
-	"math"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
 	"time"
 
+	"go-pixiv-proxy/pixiv"
+
 	log "github.com/sirupsen/logrus"
 )
 
 var (
-	headers = map[string]string{
-		"Referer":    "https://www.pixiv.net",
-		"User-Agent": "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/81.0.4044.113 Safari/537.36",
-	}
 	client = &http.Client{
 		Transport: &http.Transport{
 			Proxy: func(request *http.Request) (u *url.URL, e error) {
@@ -29,174 +27,26 @@ var (
 )
 
 type Context struct {
-	rw  http.ResponseWriter
-	req *http.Request
-}
-
-type illustRespsonse struct {
-	Error   bool   `json:"error"`
-	Message string `json:"message"`
-	Body    struct {
-		ID            string `json:"id"`
-		Title         string `json:"title"`
-		CreateDate    string `json:"createDate"`
-		UserId        string `json:"userId"`
-		UserName      string `json:"userName"`
-		BookmarkCount int64  `json:"bookmarkCount"`
-		ViewCount     int64  `json:"viewCount"`
-		AiType        int    `json:"aiType"`
-		XRestrict     int    `json:"xRestrict"`
-		Tags          struct {
-			Tags []struct {
-				Tag       string `json:"tag"`
-				Locked    bool   `json:"locked"`
-				Deletable bool   `json:"deletable"`
-				UserId    string `json:"userId"`
-				UserName  string `json:"userName"`
-			} `json:"tags"`
-		} `json:"tags"`
-		Urls struct {
-			Original string `json:"original"`
-			Regular  string `json:"regular"`
-			Small    string `json:"small"`
-			Thumb    string `json:"thumb"`
-			Mini     string `json:"mini"`
-		} `json:"urls"`
-		PageCount int `json:"pageCount"`
-	}
+	rw     http.ResponseWriter
+	req    *http.Request
+	params map[string]string
+	// cache 路由的缓存策略分类
+	cache string
 }
 
-type pagesResponse struct {
-	Error   bool   `json:"error"`
-	Message string `json:"message"`
-	Body    []struct {
-		Urls struct {
-			Original  string `json:"original"`
-			Regular   string `json:"regular"`
-			Small     string `json:"small"`
-			ThumbMini string `json:"thumb_mini"`
-		} `json:"urls"`
-		Width  int `json:"width"`
-		Height int `json:"height"`
-	} `json:"body"`
+// pixivClient 返回当前配置对应的客户端，cookie 热重载后自动使用新的客户端
+func pixivClient() *pixiv.Client {
+	return conf().client
 }
 
-type searchResponse struct {
-	Error   bool   `json:"error"`
-	Message string `json:"message"`
-	Body    struct {
-		IllustManga struct {
-			Total    int64 `json:"total"`
-			LastPage int   `json:"last_page"`
-			Data     []struct {
-				Id        string   `json:"id"`
-				Title     string   `json:"title"`
-				XRestrict int      `json:"xRestrict"`
-				Url       string   `json:"url"`
-				Tags      []string `json:"tags"`
-				UserId    string   `json:"userId"`
-				UserName  string   `json:"userName"`
-			} `json:"data"`
-		} `json:"illustManga"`
-	} `json:"body"`
-}
-
-type rankingsResponse struct {
-	Contents  []rankingContents `json:"contents"`
-	Mode      string            `json:"mode"`
-	Content   string            `json:"content"`
-	Page      int               `json:"page"`
-	Prev      any               `json:"prev"`
-	Next      any               `json:"next"`
-	Date      string            `json:"date"`
-	PrevDate  string            `json:"prev_date"`
-	NextDate  any               `json:"next_date"`
-	RankTotal int               `json:"rank_total"`
-}
-
-type rankingContents struct {
-	Title             string   `json:"title"`
-	Date              string   `json:"date"`
-	Tags              []string `json:"tags"`
-	Url               string   `json:"url"`
-	IllustType        string   `json:"illust_type"`
-	IllustBookStyle   string   `json:"illust_book_style"`
-	IllustPageCount   string   `json:"illust_page_count"`
-	UserName          string   `json:"user_name"`
-	ProfileImg        string   `json:"profile_img"`
-	IllustContentType struct {
-		Sexual     int  `json:"sexual"`
-		Lo         bool `json:"lo"`
-		Grotesque  bool `json:"grotesque"`
-		Violent    bool `json:"violent"`
-		HomoSexual bool `json:"homosexual"`
-		Drug       bool `json:"drug"`
-		Thoughts   bool `json:"thoughts"`
-		Antisocial bool `json:"antisocial"`
-		Religion   bool `json:"religion"`
-		Original   bool `json:"original"`
-		Furry      bool `json:"furry"`
-		Bl         bool `json:"bl"`
-		Yuri       bool `json:"yuri"`
-	} `json:"illust_content_type"`
-	IllustSeries          any    `json:"illust_series"`
-	IllustId              int64  `json:"illust_id"`
-	Width                 int    `json:"width"`
-	Height                int    `json:"height"`
-	UserId                int64  `json:"user_id"`
-	Rank                  int    `json:"rank"`
-	YesRank               int    `json:"yes_rank"`
-	RatingCount           int    `json:"rating_count"`
-	ViewCount             int    `json:"view_count"`
-	IllustUploadTimestamp int64  `json:"illust_upload_timestamp"`
-	Attr                  string `json:"attr"`
-	IsBookmarked          bool   `json:"is_bookmarked"`
-	Bookmarkable          bool   `json:"bookmarkable"`
-}
-
-type memberIllustResponse struct {
-	Error   bool   `json:"error"`
-	Message string `json:"message"`
-	Body    struct {
-		Illusts map[string]interface{} `json:"illusts"`
-	} `json:"body"`
-}
-
-type searchUserResponse struct {
-	Error   bool   `json:"error"`
-	Message string `json:"message"`
-	Body    struct {
-		UserId     string `json:"userId"`
-		Name       string `json:"name"`
-		Image      string `json:"image"`
-		ImageBig   string `json:"imageBig"`
-		Premium    bool   `json:"premium"`
-		IsFllowed  bool   `json:"isFollowed"`
-		IsMypixiv  bool   `json:"isMypixiv"`
-		IsBlocking bool   `json:"isBlocking"`
-		BackGround struct {
-			Repeat    string `json:"repeat"`
-			Color     string `json:"color"`
-			Url       string `json:"url"`
-			IsPrivate bool   `json:"isPrivate"`
-		} `json:"background"`
-		SketchLiveId int64 `json:"sketchLiveId"`
-		Partial      int   `json:"partial"`
-		SketchLives  []struct {
-		} `json:"sketchLives"`
-		Commission any `json:"commission"` // string or null
-	} `json:"body"`
-}
-
-type reqOptions struct {
-	Mode int
-	Page float64
+// Param 返回路由中匹配到的路径参数
+func (c *Context) Param(name string) string {
+	return c.params[name]
 }
 
 func (c *Context) write(b []byte, status int) {
-	// c.WriteHeader(status)
 	c.rw.Header().Set("Content-Length", strconv.Itoa(len(b)))
-	_ = status
+	c.rw.WriteHeader(status)
 	_, err := c.rw.Write(b)
 	if err != nil {
 		log.Error(err)
@@ -207,61 +57,72 @@ func (c *Context) String(status int, s string) {
 	c.write([]byte(s), status)
 }
 
+func (c *Context) JSON(status int, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		c.Error(500, err.Error())
+		return
+	}
+	c.rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if status == http.StatusOK {
+		if c.wantsRewrite() {
+			b = c.rewriteJSONURLs(b)
+		}
+		c.writeCacheable(b)
+		return
+	}
+	c.rw.Header().Set("Cache-Control", "no-cache")
+	c.write(b, status)
+}
+
 func (c *Context) WriteHeader(statusCode int) {
 	c.rw.WriteHeader(statusCode)
 }
 
-func proxyHttpReq(c *Context, url string, errMsg string, options ...reqOptions) {
-	mode := 0
-	page := 0.0
-	for _, opt := range options {
-		mode = opt.Mode
-		page = opt.Page
+// PixivError 将 pixiv 客户端返回的错误转换为响应
+func (c *Context) PixivError(err error) {
+	var apiErr *pixiv.APIError
+	var statusErr *pixiv.StatusError
+	switch {
+	case errors.Is(err, context.Canceled):
+		// 客户端已断开或服务正在关闭
+		log.Debug(err)
+	case errors.As(err, &apiErr):
+		if apiErr.StatusCode == 404 {
+			c.Error(404, apiErr.Error())
+			return
+		}
+		c.Error(502, apiErr.Error())
+	case errors.As(err, &statusErr):
+		c.Error(502, statusErr.Error())
+	default:
+		log.Warn(err)
+		c.Error(502, "pixiv api error")
 	}
+}
 
-	resp, err := httpGet(url)
+// proxyStream 请求 pixiv 并把响应转发给客户端，只保留 passthrough 中列出的上游响应头
+func proxyStream(c *Context, url string, errMsg string, passthrough []string) {
+	resp, err := pixivClient().Get(c.req.Context(), url)
 	if err != nil {
 		c.String(500, errMsg)
 		return
 	}
 	defer resp.Body.Close()
-	replaceHeader(c.rw.Header(), resp.Header)
-	resp.Header.Del("Cookie")
-	resp.Header.Del("Set-Cookie")
-	if mode == 1 {
-		c.write(c.GetArtWorkInfo(resp, url, errMsg), 200)
-	} else if mode == 2 {
-		c.write(c.GetSearchResults(resp, url, errMsg, page), 200)
-	} else if mode == 3 {
-		c.write(c.GetUserInfo(resp, url, errMsg), 200)
-	} else if mode == 4 {
-		_, _ = io.Copy(c.rw, resp.Body)
-		// TODO Tags
-	} else if mode == 5 {
-		c.write(c.GetRankingResults(resp, url, errMsg, page), 200)
-	} else if mode == 6 {
-		c.write(c.GetMemberIllusts(resp, url, errMsg, page), 200)
-	} else {
-		_, _ = io.Copy(c.rw, resp.Body)
-	}
+	copyAllowedHeaders(c.rw.Header(), resp.Header, passthrough)
+	if cc := c.cacheControl(); cc != "" && resp.StatusCode == http.StatusOK {
+		c.rw.Header().Set("Cache-Control", cc)
+	} else if resp.StatusCode != http.StatusOK {
+		c.rw.Header().Set("Cache-Control", "no-cache")
+	}
+	c.rw.WriteHeader(resp.StatusCode)
+	_, _ = io.Copy(c.rw, resp.Body)
 }
 
-func (c *Context) GetRankingResults(resp *http.Response, url string, errMsg string, optPage ...float64) []byte {
-	p, err := io.ReadAll(resp.Body)
-	if err != nil {
-		c.String(500, errMsg)
-		return nil
-	}
-	var rankings rankingsResponse
-	err = json.Unmarshal(p, &rankings)
-	if err != nil {
-		c.String(500, err.Error())
-		return nil
-	}
+func GetRankingResults(rankings *pixiv.Ranking, optPage ...float64) *IllustList {
 	startNum := 0
 	endNum := len(rankings.Contents)
 	if endNum == 0 {
-		endNum = 0
 		log.Debug("no results")
 	} else {
 		if optPage != nil {
@@ -270,145 +131,67 @@ func (c *Context) GetRankingResults(resp *http.Response, url string, errMsg stri
 		}
 	}
 
-	var illust []map[string]interface{}
+	var illusts []Illust
 	for i := startNum; i < endNum; i++ {
-		data := rankings.Contents[i]
-		var tags []map[string]string
-		for j := 0; j < len(data.Tags); j++ {
-			var tag = map[string]string{
-				"tag": data.Tags[j],
-			}
-			tags = append(tags, tag)
-		}
-		var illustData = map[string]interface{}{
-			"id":         data.IllustId,
-			"title":      data.Title,
-			"x_restrict": data.IllustContentType.Sexual,
-			"meta_single_page": map[string]interface{}{
-				"image_urls": map[string]string{
-					"original": data.Url,
-				},
-			},
-			"image_urls": map[string]string{
-				"large": data.Url,
-			},
-			"tags": tags,
-			"user": map[string]string{
-				"id":   strconv.FormatInt(data.UserId, 10),
-				"name": data.UserName,
-			},
-			"total_bookmarks": 0,
+		il := illustFromRanking(&rankings.Contents[i])
+		// 排行榜数据中没有 x_restrict，按榜单类型补上
+		if strings.HasSuffix(rankings.Mode, "_r18g") {
+			il.XRestrict = 2
+		} else if strings.HasSuffix(rankings.Mode, "_r18") && il.XRestrict < 1 {
+			il.XRestrict = 1
 		}
-		illust = append(illust, illustData)
+		illusts = append(illusts, il)
 	}
+	ret := newIllustList(illusts)
 	date, _ := time.Parse("20060102", rankings.Date)
-	var ret = map[string]interface{}{
-		"illusts":  illust,
-		"length":   len(illust), // len(searchResults.Body.IllustManga.Data)
-		"next_url": "date=" + date.Format(time.DateOnly),
-	}
-	p, err = json.Marshal(ret)
-	if err != nil {
-		c.String(500, errMsg)
-		return nil
-	}
-	return p
+	ret.NextURL = "date=" + date.Format(time.DateOnly)
+	return ret
 }
 
-func (c *Context) GetArtWorkInfo(resp *http.Response, url string, errMsg string, opt ...bool) []byte {
+// GetArtWorkInfo 获取作品详情，disableMeta 为 true 时不请求多页作品的每页地址
+func GetArtWorkInfo(ctx context.Context, pid string, opt ...bool) (*IllustDetail, error) {
 	disableMeta := false
 	if len(opt) > 0 {
 		disableMeta = opt[0]
 	}
-	p, err := io.ReadAll(resp.Body)
-	if err != nil {
-		c.String(500, errMsg)
-		return nil
-	}
-	var illust illustRespsonse
-	err = json.Unmarshal(p, &illust)
+	illust, err := pixivClient().Illust(ctx, pid)
 	if err != nil {
-		c.String(500, errMsg)
-		return nil
-	}
-	if illust.Error {
-		c.String(500, fmt.Sprintf("pixiv api error: %s", illust.Message))
-		return nil
-	}
-	var tags []map[string]interface{}
-	for i := 0; i < len(illust.Body.Tags.Tags); i++ {
-		tagMap := map[string]interface{}{
-			"tag": illust.Body.Tags.Tags[i].Tag,
-		}
-		tags = append(tags, tagMap)
-	}
-	var ret = map[string]interface{}{
-		"illust": map[string]interface{}{
-			"id":              illust.Body.ID,
-			"title":           illust.Body.Title,
-			"create_date":     illust.Body.CreateDate,
-			"total_bookmarks": illust.Body.BookmarkCount,
-			"total_view":      illust.Body.ViewCount,
-			"illust_ai_type":  illust.Body.AiType,
-			"x_restrict":      illust.Body.XRestrict,
-			"tags":            tags,
-			"length":          illust.Body.PageCount,
-			"user": map[string]string{
-				"id":   illust.Body.UserId,
-				"name": illust.Body.UserName,
-			},
-		},
+		return nil, err
 	}
-	if illust.Body.PageCount == 1 || disableMeta {
-		singleData := map[string]interface{}{
-			"meta_single_page": map[string]string{
-				"original_image_url": illust.Body.Urls.Original,
-			},
-		}
-		ret["illust"].(map[string]interface{})["meta_single_page"] = singleData["meta_single_page"]
-	} else {
-		resp, err := httpGet(url + "/pages")
-		if err != nil {
-			c.String(500, errMsg)
-			return nil
-		}
-		defer resp.Body.Close()
-		//replaceHeader(c.rw.Header(), resp.Header)
-		//resp.Header.Del("Cookie")
-		//resp.Header.Del("Set-Cookie")
-		p, err := io.ReadAll(resp.Body)
+	var pages []pixiv.Page
+	if illust.PageCount > 1 && !disableMeta {
+		pages, err = pixivClient().Pages(ctx, pid)
 		if err != nil {
-			c.String(500, errMsg)
-			return nil
+			return nil, err
 		}
-		var pages pagesResponse
-		err = json.Unmarshal(p, &pages)
-		if err != nil {
-			c.String(500, errMsg)
-			return nil
-		}
-		if pages.Error {
-			c.String(500, fmt.Sprintf("pixiv api error: %s", pages.Message))
-			return nil
-		}
-		metaData := map[string]interface{}{
-			"meta_pages": []map[string]interface{}{},
-		}
-		for i := 0; i < illust.Body.PageCount; i++ {
-			metaData["meta_pages"] = append(metaData["meta_pages"].([]map[string]interface{}), map[string]interface{}{
-				"image_urls": map[string]interface{}{
-					"original": pages.Body[i].Urls.Original,
-				},
-			})
-		}
-		ret["illust"].(map[string]interface{})["meta_pages"] = metaData["meta_pages"]
 	}
-	p, err = json.Marshal(ret)
-	if err != nil {
-		c.String(500, errMsg)
-		return nil
+	return &IllustDetail{
+		Illust:        illustFromDetail(illust, pages),
+		SeriesContext: seriesContextFromNav(illust.SeriesNavData),
+	}, nil
+}
+
+// parsePage 解析从 1 开始的 page 参数，留空时为 1
+func parsePage(query url.Values) (int, bool) {
+	s := query.Get("page")
+	if s == "" {
+		return 1, true
 	}
-	return p
+	page, err := strconv.Atoi(s)
+	return page, err == nil && page > 0
+}
+
+// getPageRange 返回第 page 页（从 1 开始）在长度为 total 的列表中的范围
+func getPageRange(page, size, total int) (int, int) {
+	start := (page - 1) * size
+	if start > total {
+		start = total
+	}
+	end := start + size
+	if end > total {
+		end = total
+	}
+	return start, end
 }
 
 func getTargetPageRange(page float64, total int) (int, int) {
@@ -437,26 +220,10 @@ func getTargetPageRange(page float64, total int) (int, int) {
 	return s, e
 }
 
-func (c *Context) GetSearchResults(resp *http.Response, url string, errMsg string, optPage ...float64) []byte {
-	p, err := io.ReadAll(resp.Body)
-	if err != nil {
-		c.String(500, errMsg)
-		return nil
-	}
-	var searchResults searchResponse
-	err = json.Unmarshal(p, &searchResults)
-	if err != nil {
-		c.String(500, errMsg)
-		return nil
-	}
-	if searchResults.Error {
-		c.String(500, fmt.Sprintf("pixiv api error: %s", searchResults.Message))
-		return nil
-	}
+func GetSearchResults(searchResults *pixiv.SearchResult, optPage ...float64) *IllustList {
 	startNum := 0
-	endNum := len(searchResults.Body.IllustManga.Data)
+	endNum := len(searchResults.IllustManga.Data)
 	if endNum == 0 {
-		endNum = 0
 		log.Debug("no results")
 	} else {
 		if optPage != nil {
@@ -465,246 +232,125 @@ func (c *Context) GetSearchResults(resp *http.Response, url string, errMsg strin
 		}
 	}
 
-	var illust []map[string]interface{}
+	var illusts []Illust
 	for i := startNum; i < endNum; i++ {
-		data := searchResults.Body.IllustManga.Data[i]
-		var tags []map[string]string
-		for j := 0; j < len(data.Tags); j++ {
-			var tag = map[string]string{
-				"tag": data.Tags[j],
-			}
-			tags = append(tags, tag)
-		}
-		var illustData = map[string]interface{}{
-			"id":         data.Id,
-			"title":      data.Title,
-			"x_restrict": data.XRestrict,
-			"meta_single_page": map[string]interface{}{
-				"image_urls": map[string]string{
-					"original": data.Url,
-				},
-			},
-			"image_urls": map[string]string{
-				"large": data.Url,
-			},
-			"tags": tags,
-			"user": map[string]string{
-				"id":   data.UserId,
-				"name": data.UserName,
-			},
-			"total_bookmarks": 0,
-		}
-		illust = append(illust, illustData)
+		illusts = append(illusts, illustFromBrief(&searchResults.IllustManga.Data[i]))
 	}
-	var ret = map[string]interface{}{
-		"illusts": illust,
-		"length":  len(illust), // len(searchResults.Body.IllustManga.Data)
-	}
-	p, err = json.Marshal(ret)
-	if err != nil {
-		c.String(500, errMsg)
-		return nil
-	}
-	return p
+	return newIllustList(illusts)
 }
 
-func (c *Context) GetUserInfo(resp *http.Response, url string, errMsg string) []byte {
-	p, err := io.ReadAll(resp.Body)
+// GetRelatedIllusts 作品的相关推荐，page 从 1 开始，每页 limit 个。
+// 第一页直接使用 recommend/init 返回的作品，之后的页按 nextIds 的顺序批量获取
+func GetRelatedIllusts(ctx context.Context, pid string, page, limit int) (*IllustList, error) {
+	rec, err := pixivClient().RecommendInit(ctx, pid, limit)
 	if err != nil {
-		c.String(500, errMsg)
-		return nil
+		return nil, err
 	}
-	var UserInfo searchUserResponse
-	err = json.Unmarshal(p, &UserInfo)
-	if err != nil {
-		c.String(500, errMsg)
-		return nil
-	}
-	if UserInfo.Error {
-		c.String(500, fmt.Sprintf("pixiv api error: %s", UserInfo.Message))
-		return nil
-	}
-	var ret = map[string]interface{}{
-		"user_previews": []map[string]interface{}{
-			{
-				"user": map[string]interface{}{
-					"id":   UserInfo.Body.UserId,
-					"name": UserInfo.Body.Name,
-					"profile_image_urls": map[string]string{
-						"medium": UserInfo.Body.Image,
-						"large":  UserInfo.Body.ImageBig,
-					},
-					"premium":    UserInfo.Body.Premium,
-					"background": UserInfo.Body.BackGround,
-				},
-			},
-		},
+	briefs := map[string]*pixiv.IllustBrief{}
+	var ids []string
+	for i := range rec.Illusts {
+		b := &rec.Illusts[i]
+		if b.IsAdContainer || b.ID == "" {
+			continue
+		}
+		briefs[b.ID] = b
+		ids = append(ids, b.ID)
 	}
-	p, err = json.Marshal(ret)
-	if err != nil {
-		c.String(500, errMsg)
-		return nil
+	for _, id := range rec.NextIDs {
+		if _, ok := briefs[id]; !ok {
+			ids = append(ids, id)
+		}
 	}
-	return p
-}
-
-func (c *Context) GetMemberIllusts(resp *http.Response, url string, errMsg string, optPage ...float64) []byte {
-	p, err := io.ReadAll(resp.Body)
-	if err != nil {
-		c.String(500, errMsg)
-		return nil
+	start, end := getPageRange(page, limit, len(ids))
+	var missing []string
+	for _, id := range ids[start:end] {
+		if _, ok := briefs[id]; !ok {
+			missing = append(missing, id)
+		}
 	}
-
-	var searchResults memberIllustResponse
-	err = json.Unmarshal(p, &searchResults)
+	more, err := pixivClient().RecommendIllusts(ctx, missing)
 	if err != nil {
-		c.String(500, errMsg)
-		return nil
-	}
-
-	if searchResults.Error {
-		c.String(500, fmt.Sprintf("pixiv api error: %s", searchResults.Message))
-		return nil
-	}
-
-	startNum := 0
-	endNum := len(searchResults.Body.Illusts)
-	if endNum == 0 {
-		endNum = 0
-		log.Debug("no results")
-	} else {
-		if optPage != nil {
-			startNum, endNum = getMemberIllustsRange(optPage[0], endNum)
-			log.Debugf("startNum: %d, endNum: %d", startNum, endNum)
-		}
+		return nil, err
 	}
-
-	i := startNum
-	var retMap = map[string]interface{}{
-		"illusts": []map[string]interface{}{},
+	for i := range more {
+		briefs[more[i].ID] = &more[i]
 	}
 
-	if startNum != -1 && endNum != -1 {
-		for k := range searchResults.Body.Illusts {
-			url := "https://www.pixiv.net/ajax/illust/" + k
-			resp, err := httpGet(url)
-			if err != nil {
-				log.Warnln(err)
-				continue
-			}
-			defer resp.Body.Close()
-			//replaceHeader(c.rw.Header(), resp.Header)
-			//resp.Header.Del("Cookie")
-			//resp.Header.Del("Set-Cookie")
-			p := c.GetArtWorkInfo(resp, url, "", true)
-			var illust map[string]interface{}
-			err = json.Unmarshal(p, &illust)
-			if err != nil {
-				log.Warnln(err)
-				continue
-			}
-			retMap["illusts"] = append(retMap["illusts"].([]map[string]interface{}), illust["illust"].(map[string]interface{}))
-			if i == endNum {
-				break
-			}
-			i++
-		}
-
-		var user = map[string]interface{}{}
-		url = "https://www.pixiv.net/ajax/user/" + retMap["illusts"].([]map[string]interface{})[0]["user"].(map[string]interface{})["id"].(string)
-		resp, err = httpGet(url)
-		if err != nil {
-			c.String(500, errMsg)
-			return nil
+	var illusts []Illust
+	for _, id := range ids[start:end] {
+		// 已删除的作品不会返回
+		if b, ok := briefs[id]; ok && !b.IsAdContainer {
+			illusts = append(illusts, illustFromBrief(b))
 		}
-		defer resp.Body.Close()
-		//copyHeader(c.rw.Header(), resp.Header)
-		//resp.Header.Del("Cookie")
-		//resp.Header.Del("Set-Cookie")
-		p = c.GetUserInfo(resp, url, errMsg)
-		err = json.Unmarshal(p, &user)
-		if err != nil {
-			c.String(500, errMsg)
-			return nil
-		}
-		retMap["user"] = user["user_previews"].(interface{}).([]interface{})[0].(map[string]interface{})["user"]
 	}
-
-	retMap["length"] = len(retMap["illusts"].([]map[string]interface{}))
-	p, err = json.Marshal(retMap)
-	if err != nil {
-		c.String(500, errMsg)
-		return nil
+	ret := newIllustList(illusts)
+	if end < len(ids) {
+		ret.NextURL = "pid=" + pid + "&limit=" + strconv.Itoa(limit) + "&page=" + strconv.Itoa(page+1)
 	}
-	return p
+	return ret, nil
 }
 
-func getMemberIllustsRange(page float64, total int) (int, int) {
-	if page == 0 {
-		page = 1
-	}
-	p := math.Ceil(float64(total) / 30)
-	if page <= p {
-		startNum := (int(page) - 1) * 30
-		endNum := startNum + 29
-		if endNum > total {
-			endNum = total
-		}
-		return startNum, endNum
-	} else {
-		return -1, -1
+func GetUserInfo(user *pixiv.User) *UserPreviews {
+	return &UserPreviews{
+		UserPreviews: []UserPreview{{User: userFromPixiv(user)}},
 	}
 }
 
-func httpGet(u string) (*http.Response, error) {
-	req, err := http.NewRequest("GET", u, nil)
-	if err != nil {
-		return nil, err
-	}
-	for k, v := range headers {
-		req.Header.Set(k, v)
-	}
-	req.Header.Set("Cookie", cookies)
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	return resp, nil
-}
+// memberIllustsPageSize /api/member_illust 每页的数量
+const memberIllustsPageSize = 30
 
-func httpGetReadCloser(u string) (io.ReadCloser, error) {
-	resp, err := httpGet(u)
+// GetMemberIllusts 用户的作品，按 id 从新到旧排列，page 从 1 开始。
+// workType 为 illust、manga 或空（全部），每页的作品信息通过一次批量请求获取
+func GetMemberIllusts(ctx context.Context, uid string, page int, workType string) (*IllustList, error) {
+	all, err := pixivClient().UserProfileAll(ctx, uid)
 	if err != nil {
 		return nil, err
 	}
-	return resp.Body, nil
-}
-
-func httpGetBytes(url string) ([]byte, error) {
-	body, err := httpGetReadCloser(url)
+	var set pixiv.IDSet
+	switch workType {
+	case "illust":
+		set = all.Illusts
+	case "manga":
+		set = all.Manga
+	default:
+		set = all.Illusts.Union(all.Manga)
+	}
+	ids := set.Sorted()
+	start, end := getPageRange(page, memberIllustsPageSize, len(ids))
+
+	// 用户信息与作品信息同时获取
+	var (
+		user    *pixiv.User
+		userErr error
+		done    = make(chan struct{})
+	)
+	go func() {
+		defer close(done)
+		user, userErr = pixivClient().User(ctx, uid)
+	}()
+	works, err := pixivClient().UserIllusts(ctx, uid, ids[start:end])
+	<-done
 	if err != nil {
 		return nil, err
 	}
-	defer body.Close()
-	b, err := io.ReadAll(body)
-	if err != nil {
-		return nil, err
+	if userErr != nil {
+		return nil, userErr
 	}
-	return b, nil
-}
 
-func copyHeader(dst, src http.Header) {
-	for k, vv := range src {
-		for _, v := range vv {
-			dst.Add(k, v)
+	illusts := make([]Illust, 0, end-start)
+	for _, id := range ids[start:end] {
+		if w, ok := works[id]; ok {
+			illusts = append(illusts, illustFromBrief(&w))
 		}
 	}
-}
-
-func replaceHeader(dst, src http.Header) {
-	for k, vv := range src {
-		for _, v := range vv {
-			dst.Set(k, v)
+	ret := newIllustList(illusts)
+	u := userFromPixiv(user)
+	ret.User = &u
+	if end < len(ids) {
+		ret.NextURL = "id=" + uid + "&page=" + strconv.Itoa(page+1)
+		if workType != "" {
+			ret.NextURL += "&type=" + workType
 		}
 	}
+	return ret, nil
 }
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// 路由组，用于按监听开关一类路由
+const (
+	groupImages  = "images"
+	groupAPI     = "api"
+	groupAdmin   = "admin"
+	groupMetrics = "metrics"
+	groupEmbed   = "embed"
+	groupFeed    = "feed"
+)
+
+var requestCount = expvar.NewMap("requests")
+
+// explicitGroups 只在监听的 routes 中明确列出时才启用的路由组
+var explicitGroups = []string{groupAdmin, groupMetrics}
+
+// signingGroups 会在返回中生成签名地址的路由组
+var signingGroups = []string{groupAPI, groupEmbed, groupFeed, groupAdmin}
+
+// reqPolicy 由监听配置和 api key 决定的、作用于单个请求的策略
+type reqPolicy struct {
+	listener *ListenerConfig
+	filter   *FilterPolicy
+	// authenticated 监听需要鉴权，响应不能被共享缓存保存
+	authenticated bool
+}
+
+type policyCtxKey struct{}
+
+// policy 返回当前请求的策略，未经过监听（如直接调用 router）时返回 nil
+func (c *Context) policy() *reqPolicy {
+	p, _ := c.req.Context().Value(policyCtxKey{}).(*reqPolicy)
+	return p
+}
+
+// routeEnabled routes 留空时启用除 admin、metrics 以外的全部路由组
+func (l *ListenerConfig) routeEnabled(group string) bool {
+	if group == "" {
+		return true
+	}
+	if len(l.Routes) == 0 {
+		return !in(explicitGroups, group)
+	}
+	return in(l.Routes, group)
+}
+
+// routeEnabled 当前请求所在的监听是否启用了该路由组，未经过监听时视为启用
+func (c *Context) routeEnabled(group string) bool {
+	p := c.policy()
+	return p == nil || p.listener.routeEnabled(group)
+}
+
+// signingGroup 返回监听启用的第一个会生成签名地址的路由组，没有时返回空
+func (l *ListenerConfig) signingGroup() string {
+	for _, g := range signingGroups {
+		if l.routeEnabled(g) {
+			return g
+		}
+	}
+	return ""
+}
+
+// handleMetrics 输出 expvar 变量，不包含 cmdline，避免泄露命令行中的 cookie 等参数
+func handleMetrics(c *Context) {
+	c.rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	var b strings.Builder
+	b.WriteString("{\n")
+	first := true
+	expvar.Do(func(kv expvar.KeyValue) {
+		if kv.Key == "cmdline" {
+			return
+		}
+		if !first {
+			b.WriteString(",\n")
+		}
+		first = false
+		fmt.Fprintf(&b, "%q: %s", kv.Key, kv.Value)
+	})
+	b.WriteString("\n}\n")
+	c.rw.WriteHeader(200)
+	io.WriteString(c.rw, b.String())
+}
+
+// enabled 是否配置了 api key 或 basic auth
+func (p *AuthPolicy) enabled() bool {
+	return len(p.APIKeys) > 0 || len(p.BasicAuth) > 0
+}
+
+// authorize 校验 X-API-Key 请求头 / key 参数，或 basic auth，通过 api key 鉴权时返回该 key
+func (p *AuthPolicy) authorize(req *http.Request) (string, bool) {
+	if !p.enabled() {
+		return "", true
+	}
+	key := req.Header.Get("X-API-Key")
+	if key == "" {
+		key = req.URL.Query().Get("key")
+	}
+	if key != "" {
+		for _, k := range p.APIKeys {
+			if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+				return k, true
+			}
+		}
+	}
+	if user, pass, ok := req.BasicAuth(); ok {
+		if want, ok := p.BasicAuth[user]; ok && subtle.ConstantTimeCompare([]byte(want), []byte(pass)) == 1 {
+			return "", true
+		}
+	}
+	return "", false
+}
+
+// newListenerHandler 按第 i 个监听的配置过滤路由组并鉴权，
+// 每次请求都读取当前配置，使路由组和鉴权策略可以热重载
+func newListenerHandler(i int, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		cfg := conf()
+		lc := cfg.listeners()[i]
+		group := routeGroup(req.URL.Path)
+		if !lc.routeEnabled(group) {
+			// 与未知路由相同，不暴露被关闭的路由组
+			(&Context{rw: rw, req: req}).Error(404, "route not found")
+			return
+		}
+		// 预检请求不带鉴权信息，需要在鉴权之前应答
+		if group != "" && !handleCORS(cfg, group, rw, req) {
+			return
+		}
+		var key string
+		if group != "" {
+			var ok bool
+			if key, ok = lc.Auth.authorize(req); !ok {
+				if len(lc.Auth.BasicAuth) > 0 {
+					rw.Header().Set("WWW-Authenticate", `Basic realm="go-pixiv-proxy"`)
+				}
+				(&Context{rw: rw, req: req}).Error(http.StatusUnauthorized, "unauthorized")
+				return
+			}
+		}
+		if group != "" && !checkHotlink(cfg, group, rw, req) {
+			return
+		}
+		filter := lc.Filter
+		if name, ok := lc.Auth.KeyFilters[key]; ok && key != "" {
+			filter = name
+		}
+		authenticated := group != "" && lc.Auth.enabled()
+		if authenticated {
+			addVary(rw.Header(), "Authorization", "X-API-Key")
+		}
+		req = req.WithContext(context.WithValue(req.Context(), policyCtxKey{}, &reqPolicy{
+			listener:      &lc,
+			filter:        cfg.Filters[filter],
+			authenticated: authenticated,
+		}))
+		if group == "" {
+			requestCount.Add("other", 1)
+		} else {
+			requestCount.Add(group, 1)
+		}
+		next.ServeHTTP(rw, req)
+	})
+}
+
+func handleAdminReload(c *Context) {
+	if err := reloadConfig(); err != nil {
+		c.Error(409, err.Error())
+		return
+	}
+	c.String(200, "ok")
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestListenerRouteGroups(t *testing.T) {
+	tests := []struct {
+		routes []string
+		group  string
+		want   bool
+	}{
+		{nil, groupImages, true},
+		{nil, groupAPI, true},
+		{nil, groupAdmin, false},
+		{nil, groupMetrics, false},
+		{nil, "", true},
+		{[]string{"images", "admin"}, groupAdmin, true},
+		{[]string{"images", "admin"}, groupAPI, false},
+		{[]string{"metrics"}, groupMetrics, true},
+		{[]string{"metrics"}, "", true},
+	}
+	for _, tt := range tests {
+		l := &ListenerConfig{Routes: tt.routes}
+		if got := l.routeEnabled(tt.group); got != tt.want {
+			t.Errorf("routes %v, group %q: got %v, want %v", tt.routes, tt.group, got, tt.want)
+		}
+	}
+}
+
+func TestListenerErrors(t *testing.T) {
+	useConfig(t, &Config{Listeners: []ListenerConfig{{
+		Routes: []string{"images", "api"},
+		Auth:   AuthPolicy{APIKeys: []string{"secret"}, BasicAuth: map[string]string{"u": "p"}},
+	}}})
+	h := newListenerHandler(0, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if p := (&Context{req: req}).policy(); p == nil || !p.authenticated {
+			t.Errorf("%s: policy %+v", req.URL, p)
+		}
+		rw.WriteHeader(204)
+	}))
+	tests := []struct {
+		target string
+		key    string
+		status int
+	}{
+		{"/metrics", "secret", 404},
+		{"/admin/sign", "secret", 404},
+		{"/api/illust?pid=1", "", 401},
+		{"/api/illust?pid=1", "wrong", 401},
+		{"/api/illust?pid=1", "secret", 204},
+		{"/api/illust?pid=1&key=secret", "", 204},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.target, nil)
+		if tt.key != "" {
+			req.Header.Set("X-API-Key", tt.key)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s key %q: status %d, want %d", tt.target, tt.key, rec.Code, tt.status)
+			continue
+		}
+		if tt.status == 204 {
+			continue
+		}
+		decodeError(t, rec)
+		if tt.status == 401 && rec.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: missing WWW-Authenticate", tt.target)
+		}
+	}
+}
@@ -3,16 +3,17 @@ package main
 import (
 	_ "embed"
 	"flag"
-	"fmt"
 	"math"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
 
+	"go-pixiv-proxy/pixiv"
+
 	log "github.com/sirupsen/logrus"
 	easy "github.com/t-tomalak/logrus-easy-formatter"
-	"github.com/tidwall/gjson"
 )
 
 var (
@@ -20,10 +21,11 @@ var (
 	port    string
 	domain  string
 	cookies string
+	rewrite bool
 	debug   bool
 	//go:embed index.html
 	indexHtml     string
-	directTypes   = []string{"img-original", "img-master", "c", "user-profile", "img-zip-ugoira"}
+	directTypes   = []string{"img-original", "img-master", "c", "user-profile", "background", "img-zip-ugoira", "novel-cover-original", "novel-cover-master"}
 	imgTypes      = []string{"original", "regular", "small", "thumb", "mini"}
 	docExampleImg = `![regular](http://example.com/98505703?t=regular)
 
@@ -34,33 +36,44 @@ var (
 ![mini](http://example.com/98505703?t=mini)`
 )
 
-type Illust struct {
-	origUrl string
-	urls    map[string]gjson.Result
-}
-
-func handlePixivProxy(rw http.ResponseWriter, req *http.Request) {
-	var err error
-	var realUrl string
-	c := &Context{
-		rw:  rw,
-		req: req,
-	}
-	path := req.URL.Path
-	log.Info(req.Method, " ", req.URL.String())
-	spl := strings.Split(path, "/")[1:]
-	switch spl[0] {
-	case "":
-		c.String(200, indexHtml)
+func handleDirectImage(c *Context) {
+	if !c.checkSignature() {
 		return
-	case "favicon.ico":
-		c.WriteHeader(404)
+	}
+	p, err := parsePximgPath(c.req.URL.Path, conf().PximgSizes)
+	if err != nil {
+		c.Error(400, err.Error())
 		return
-	case "api":
-		handleIllustInfo(c)
+	}
+	if c.filter() != nil {
+		// 直链中只有 id，需要查询作品详情后才能判断是否过滤
+		if pid := p.illustID(); pid != "" {
+			illust, err := pixivClient().Illust(c.req.Context(), pid)
+			if err != nil {
+				c.PixivError(err)
+				return
+			}
+			if c.blockedWork(illust) {
+				c.rejectFiltered()
+				return
+			}
+		}
+	}
+	proxyStream(c, pixivClient().ImageBaseURL+p.String(), "fetch pixiv image error", conf().ImageHeaders)
+}
+
+func handleIllustImage(c *Context) {
+	if !c.checkSignature() {
 		return
 	}
-	imgType := req.URL.Query().Get("t")
+	if crawlers := conf().EmbedCrawlers; len(crawlers) > 0 && c.routeEnabled(groupEmbed) {
+		addVary(c.rw.Header(), "User-Agent", "Accept")
+		if wantsEmbed(c.req, crawlers) {
+			renderEmbed(c)
+			return
+		}
+	}
+	imgType := c.req.URL.Query().Get("t")
 	if imgType == "" {
 		imgType = "original"
 	}
@@ -68,176 +81,226 @@ func handlePixivProxy(rw http.ResponseWriter, req *http.Request) {
 		c.String(400, "invalid query")
 		return
 	}
-	if in(directTypes, spl[0]) {
-		realUrl = "https://i.pximg.net" + path
-	} else {
-		if _, err = strconv.Atoi(spl[0]); err != nil {
-			c.String(400, "invalid query")
-			return
-		}
-		illust, err := getIllust(spl[0])
-		if err != nil {
-			c.String(400, "pixiv api error")
-			return
-		}
-		if r, ok := illust.urls[imgType]; ok {
-			realUrl = r.String()
-		} else {
-			c.String(400, "this image type not exists")
-			return
-		}
-		if realUrl == "" {
-			c.String(400, "this image needs login, set GPP_COOKIES env.")
-		}
-		if len(spl) > 1 {
-			realUrl = strings.Replace(realUrl, "_p0", "_p"+spl[1], 1)
-		}
+	illust, err := pixivClient().Illust(c.req.Context(), c.Param("pid"))
+	if err != nil {
+		c.PixivError(err)
+		return
+	}
+	if c.blockedWork(illust) {
+		c.rejectFiltered()
+		return
+	}
+	realUrl := illust.Urls.Map()[imgType]
+	if realUrl == "" {
+		c.String(400, "this image needs login, set GPP_COOKIES env.")
+		return
 	}
-	var GetMode int = 0
-	proxyHttpReq(c, realUrl, "fetch pixiv image error", reqOptions{Mode: GetMode})
+	if page := c.Param("page"); page != "" {
+		realUrl = strings.Replace(realUrl, "_p0", "_p"+page, 1)
+	}
+	proxyStream(c, realUrl, "fetch pixiv image error", conf().ImageHeaders)
 }
 
-func handleIllustInfo(c *Context) {
-	var GetMode int
-	Params := strings.Split(c.req.URL.Path, "/")
-	api := Params[2]
-	if api == "illust" {
-		parms := getParams(c.req.URL.RawQuery)
-		if parms == nil {
-			c.String(400, "query invalid")
-			return
-		}
-		pid := parms["pid"]
-		if pid == "" {
+func handleApiIllust(c *Context) {
+	pid := c.req.URL.Query().Get("pid")
+	if pid == "" {
+		c.String(400, "pid invalid")
+		return
+	}
+	ret, err := GetArtWorkInfo(c.req.Context(), pid)
+	if err != nil {
+		c.PixivError(err)
+		return
+	}
+	if c.filter().blockedIllust(&ret.Illust) {
+		c.Error(http.StatusUnavailableForLegalReasons, "this work is filtered")
+		return
+	}
+	c.JSON(200, ret)
+}
+
+func handleApiSearch(c *Context) {
+	query := c.req.URL.Query()
+	word := query.Get("word")
+	if word == "" {
+		c.String(400, "word invalid")
+		return
+	}
+	page := 0.0
+	if p, err := strconv.Atoi(query.Get("page")); err == nil {
+		page = float64(p)
+	}
+	targetPage, _ := strconv.Atoi(getTargetPage(page))
+	res, err := pixivClient().Search(c.req.Context(), word, targetPage)
+	if err != nil {
+		c.PixivError(err)
+		return
+	}
+	ret := GetSearchResults(res, page)
+	c.filterList(ret)
+	c.JSON(200, ret)
+}
+
+func handleApiSearchUser(c *Context) {
+	uid := c.req.URL.Query().Get("word")
+	if uid == "" {
+		c.String(400, "uid or name invalid")
+		return
+	}
+	user, err := pixivClient().User(c.req.Context(), uid)
+	if err != nil {
+		c.PixivError(err)
+		return
+	}
+	c.JSON(200, GetUserInfo(user))
+}
+
+// tagsMaxIDs /api/tags 一次最多查询的作品数量
+const tagsMaxIDs = 100
+
+// handleApiTags 作品中常用的标签，pids 为逗号分隔的作品 id
+func handleApiTags(c *Context) {
+	pids := strings.Split(c.Param("pids"), ",")
+	if len(pids) > tagsMaxIDs {
+		c.String(400, "too many pids")
+		return
+	}
+	for _, pid := range pids {
+		if !isDigits(pid) {
 			c.String(400, "pid invalid")
 			return
 		}
-		GetMode = 1
-		proxyHttpReq(c, "https://www.pixiv.net/ajax/illust/"+pid, "pixiv api error", reqOptions{Mode: GetMode})
-	} else if api == "search" {
-		parms := getParams(c.req.URL.RawQuery)
-		if parms == nil {
-			c.String(400, "query invalid")
-			return
-		}
-		word := parms["word"]
-		if word == "" {
-			c.String(400, "word invalid")
-			return
-		}
-		page := 0.0
-		reqPage := parms["page"]
-		if reqPage != "" {
-			if p, err := strconv.Atoi(reqPage); err == nil {
-				reqPage = "?p=" + getTargetPage(float64(p))
-				page = float64(p)
-			}
-		}
-		GetMode = 2
-		proxyHttpReq(c, "https://www.pixiv.net/ajax/search/artworks/"+word+reqPage, "pixiv api error", reqOptions{GetMode, page})
-	} else if api == "search_user" {
-		params := getParams(c.req.URL.RawQuery)
-		if params["word"] == "" {
-			c.String(400, "uid or name invalid")
-			return
-		}
-		uid := params["word"]
-		GetMode = 3
-		proxyHttpReq(c, "https://www.pixiv.net/ajax/user/"+uid, "pixiv api error", reqOptions{Mode: GetMode})
-	} else if api == "tags" {
-		tag := Params[len(Params)-1]
-		GetMode = 4
-		proxyHttpReq(c, "https://www.pixiv.net/ajax/tags/frequent/illust"+tag, "pixiv api error", reqOptions{Mode: GetMode})
-	} else if api == "rank" {
-		params := getParams(c.req.URL.RawQuery)
-		if params == nil {
-			c.String(400, "query invalid")
-			return
-		}
-		mode := params["mode"]
-		content := params["content"]
-		if mode != "" {
-			Mode := "daily"
-			if strings.Contains(mode, "_manga") {
-				mode = strings.ReplaceAll(mode, "_manga", "")
-				content = "&content=manga"
-			}
-			if strings.Contains(mode, "male") || strings.Contains(mode, "female") {
-				Mode = strings.ReplaceAll(mode, "day_", "")
-			} else if strings.Contains(mode, "original") {
-				Mode = "original"
-			} else if strings.Contains(mode, "rookie") {
-				Mode = "rookie"
-			} else if strings.Contains(mode, "day") {
-				Mode = strings.ReplaceAll(mode, "day", "daily")
-			} else if strings.Contains(mode, "week") {
-				if !strings.Contains(mode, "weekly") {
-					Mode = strings.ReplaceAll(mode, "week", "weekly")
-				}
-			} else if strings.Contains(mode, "month") {
-				if !strings.Contains(mode, "monthly") {
-					Mode = strings.ReplaceAll(mode, "month", "monthly")
-				}
-			}
-			mode = "&mode=" + Mode
+	}
+	tags, err := pixivClient().FrequentTags(c.req.Context(), pids)
+	if err != nil {
+		c.PixivError(err)
+		return
+	}
+	ret := &TagList{Tags: make([]Tag, 0, len(tags))}
+	for _, t := range tags {
+		ret.Tags = append(ret.Tags, Tag{Tag: t.Tag, TranslatedName: t.TagTranslation})
+	}
+	c.JSON(200, ret)
+}
+
+func handleApiRank(c *Context) {
+	opt, page, err := parseRankingQuery(c.req.URL.Query())
+	if err != nil {
+		c.String(400, "page invalid")
+		return
+	}
+	ranking, err := pixivClient().Ranking(c.req.Context(), opt)
+	if err != nil {
+		c.PixivError(err)
+		return
+	}
+	ret := GetRankingResults(ranking, page)
+	c.filterList(ret)
+	c.JSON(200, ret)
+}
+
+// parseRankingQuery 解析排行榜的 mode、content、date、page 参数
+func parseRankingQuery(query url.Values) (pixiv.RankingOptions, float64, error) {
+	opt := pixiv.RankingOptions{Content: query.Get("content")}
+	if mode := query.Get("mode"); mode != "" {
+		Mode := "daily"
+		if strings.Contains(mode, "_manga") {
+			mode = strings.ReplaceAll(mode, "_manga", "")
+			opt.Content = "manga"
 		}
-		date := params["date"]
-		if date != "" {
-			if strings.Contains(date, "-") {
-				date = strings.ReplaceAll(date, "-", "")
+		if strings.Contains(mode, "male") || strings.Contains(mode, "female") {
+			Mode = strings.ReplaceAll(mode, "day_", "")
+		} else if strings.Contains(mode, "original") {
+			Mode = "original"
+		} else if strings.Contains(mode, "rookie") {
+			Mode = "rookie"
+		} else if strings.Contains(mode, "day") {
+			Mode = strings.ReplaceAll(mode, "day", "daily")
+		} else if strings.Contains(mode, "week") {
+			if !strings.Contains(mode, "weekly") {
+				Mode = strings.ReplaceAll(mode, "week", "weekly")
 			}
-			date = "&date=" + date
-		}
-		if content != "" {
-			content = "&content=" + content
-		}
-		page := 0.0
-		reqPage := params["page"]
-		if reqPage != "" {
-			p, err := strconv.Atoi(reqPage)
-			if err != nil {
-				c.String(400, "page invalid")
-				return
+		} else if strings.Contains(mode, "month") {
+			if !strings.Contains(mode, "monthly") {
+				Mode = strings.ReplaceAll(mode, "month", "monthly")
 			}
-			reqPage = "&p=" + getTargetPage(float64(p))
-			page = float64(p)
-		}
-		GetMode = 5
-		proxyHttpReq(c, "https://www.pixiv.net/ranking.php?format=json"+mode+date+content+reqPage, "pixiv api error", reqOptions{GetMode, page})
-	} else if api == "member_illust" {
-		params := getParams(c.req.URL.RawQuery)
-		if params == nil {
-			c.String(400, "query invalid")
-			return
-		}
-		uid := params["id"]
-		if uid == "" {
-			c.String(400, "word invalid")
-			return
 		}
-		page := 0.0
-		reqPage := params["page"]
-		if reqPage != "" {
-			if p, err := strconv.Atoi(reqPage); err == nil {
-				// reqPage = "?p=" + getTargetPage(float64(p))
-				page = float64(p)
-			}
+		opt.Mode = Mode
+	}
+	opt.Date = strings.ReplaceAll(query.Get("date"), "-", "")
+	page := 0.0
+	if reqPage := query.Get("page"); reqPage != "" {
+		p, err := strconv.Atoi(reqPage)
+		if err != nil {
+			return opt, 0, err
 		}
-		GetMode = 6
-		proxyHttpReq(c, "https://www.pixiv.net/ajax/user/"+uid+"/profile/all", "pixiv api error", reqOptions{GetMode, page})
+		page = float64(p)
+		opt.Page, _ = strconv.Atoi(getTargetPage(page))
 	}
+	return opt, page, nil
 }
 
-func getParams(rawQuery string) map[string]string {
-	query := strings.Split(rawQuery, "&")
-	parms := make(map[string]string, len(query))
-	for _, q := range query {
-		t := strings.Split(q, "=")
-		if len(t) == 2 {
-			parms[t[0]] = t[1]
+func handleApiMemberIllust(c *Context) {
+	query := c.req.URL.Query()
+	uid := query.Get("id")
+	if !isDigits(uid) {
+		c.String(400, "word invalid")
+		return
+	}
+	page := 1
+	if p, err := strconv.Atoi(query.Get("page")); err == nil && p > 1 {
+		page = p
+	}
+	workType := query.Get("type")
+	switch workType {
+	case "", "all":
+		workType = ""
+	case "illust", "illusts":
+		workType = "illust"
+	case "manga":
+	default:
+		c.String(400, "type invalid")
+		return
+	}
+	ret, err := GetMemberIllusts(c.req.Context(), uid, page, workType)
+	if err != nil {
+		c.PixivError(err)
+		return
+	}
+	c.filterList(ret)
+	c.JSON(200, ret)
+}
+
+// /api/related 每页的数量，默认与搜索相同
+const (
+	relatedPageSize    = 30
+	relatedMaxPageSize = 100
+)
+
+func handleApiRelated(c *Context) {
+	query := c.req.URL.Query()
+	pid := query.Get("pid")
+	page, ok := parsePage(query)
+	if !isDigits(pid) || !ok {
+		c.Error(400, "pid or page invalid")
+		return
+	}
+	limit := relatedPageSize
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > relatedMaxPageSize {
+			c.Error(400, "limit invalid")
+			return
 		}
+		limit = n
 	}
-	return parms
+	ret, err := GetRelatedIllusts(c.req.Context(), pid, page, limit)
+	if err != nil {
+		c.PixivError(err)
+		return
+	}
+	c.filterList(ret)
+	c.JSON(200, ret)
 }
 
 // 获取需要访问的目标页，如带Opt，则返回值包含(opt位)小鼠
@@ -251,19 +314,6 @@ func getTargetPage(page float64, opt ...int) string {
 	}
 }
 
-func getIllust(pid string) (*Illust, error) {
-	b, err := httpGetBytes("https://www.pixiv.net/ajax/illust/" + pid)
-	if err != nil {
-		return nil, err
-	}
-	g := gjson.ParseBytes(b)
-	imgUrl := g.Get("body.urls.original").String()
-	return &Illust{
-		origUrl: imgUrl,
-		urls:    g.Get("body.urls").Map(),
-	}, nil
-}
-
 func in(orig []string, str string) bool {
 	for _, b := range orig {
 		if b == str {
@@ -273,19 +323,30 @@ func in(orig []string, str string) bool {
 	return false
 }
 
-func checkEnv() {
+func checkEnv(cfg *Config) {
 	if os.Getenv("GPP_HOST") != "" {
-		host = os.Getenv("GPP_HOST")
+		cfg.Host = os.Getenv("GPP_HOST")
 	}
 	if os.Getenv("GPP_PORT") != "" {
-		port = os.Getenv("GPP_PORT")
+		cfg.Port = os.Getenv("GPP_PORT")
 	}
 	if os.Getenv("GPP_DOMAIN") != "" {
-		domain = os.Getenv("GPP_DOMAIN")
+		cfg.Domain = os.Getenv("GPP_DOMAIN")
 	}
 	if os.Getenv("GPP_COOKIES") != "" {
-		cookies = os.Getenv("GPP_COOKIES")
+		cfg.Cookies = os.Getenv("GPP_COOKIES")
+	}
+	if v := os.Getenv("GPP_REWRITE_URLS"); v != "" {
+		cfg.RewriteURLs = v == "1" || v == "true"
+	}
+}
+
+func renderIndex(domain string) string {
+	if domain == "" {
+		return indexHtml
 	}
+	s := strings.ReplaceAll(indexHtml, "{image-examples}", docExampleImg)
+	return strings.ReplaceAll(s, "http://example.com", domain)
 }
 
 func init() {
@@ -293,6 +354,9 @@ func init() {
 	flag.StringVar(&port, "p", "18090", "port")
 	flag.StringVar(&domain, "d", "", "your domain")
 	flag.StringVar(&cookies, "c", "", "cookie")
+	flag.BoolVar(&rewrite, "rewrite", false, "rewrite pixiv image urls in api responses to this proxy")
+	flag.StringVar(&configPath, "config", "", "config file (json)")
+	flag.DurationVar(&configWatch, "watch", 0, "poll config file for changes at this interval, 0 to disable")
 	flag.BoolVar(&debug, "debug", false, "debug mode")
 	log.SetFormatter(&easy.Formatter{
 		TimestampFormat: "2006-01-02 15:04:05",
@@ -307,15 +371,31 @@ func main() {
 		log.SetLevel(log.DebugLevel)
 		log.Debug("debug mode enabled")
 	}
-	checkEnv()
-	if domain != "" {
-		indexHtml = strings.ReplaceAll(indexHtml, "{image-examples}", docExampleImg)
-		indexHtml = strings.ReplaceAll(indexHtml, "http://example.com", domain)
+	if os.Getenv("GPP_CONFIG") != "" {
+		configPath = os.Getenv("GPP_CONFIG")
 	}
-	http.HandleFunc("/", handlePixivProxy)
-	log.Infof("started: %s:%s %s", host, port, domain)
-	err := http.ListenAndServe(fmt.Sprintf("%s:%s", host, port), nil)
+	cfg, err := loadConfig()
 	if err != nil {
-		log.Error("start failed: ", err)
+		log.Fatal("load config failed: ", err)
+	}
+	currentConfig.Store(cfg)
+	if flag.Arg(0) == "sign" {
+		if err = runSignCommand(cfg, flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	go watchReload()
+	listeners, err := buildListeners(cfg, appRouter)
+	if err != nil {
+		log.Fatal("start failed: ", err)
+	}
+	if cfg.Domain != "" {
+		log.Info("domain: ", cfg.Domain)
+	}
+	if err = serve(listeners); err != nil {
+		log.Error("server stopped: ", err)
+		return
 	}
+	log.Info("server stopped")
 }
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"go-pixiv-proxy/pixiv"
+)
+
+// jst pixiv 返回的时间均为日本时间
+var jst = time.FixedZone("JST", 9*60*60)
+
+// 以下为 /api/* 输出的结构，字段与 pixiv app api 保持一致，所有接口共用
+// id 统一为数字，与 app api 相同
+
+type Illust struct {
+	ID             int64          `json:"id"`
+	Title          string         `json:"title"`
+	Type           string         `json:"type,omitempty"`
+	CreateDate     string         `json:"create_date,omitempty"`
+	User           User           `json:"user"`
+	Tags           []Tag          `json:"tags"`
+	ImageURLs      ImageURLs      `json:"image_urls"`
+	MetaSinglePage MetaSinglePage `json:"meta_single_page"`
+	MetaPages      []MetaPage     `json:"meta_pages"`
+	PageCount      int            `json:"page_count"`
+	Width          int            `json:"width,omitempty"`
+	Height         int            `json:"height,omitempty"`
+	XRestrict      int            `json:"x_restrict"`
+	IllustAIType   int            `json:"illust_ai_type"`
+	TotalBookmarks int64          `json:"total_bookmarks"`
+	TotalView      int64          `json:"total_view"`
+	Series         *SeriesRef     `json:"series,omitempty"`
+	// Filtered 为 true 表示该项是被内容过滤替换的占位项
+	Filtered bool `json:"filtered,omitempty"`
+}
+
+// SeriesRef 作品所属的漫画系列
+type SeriesRef struct {
+	ID    int64  `json:"id"`
+	Title string `json:"title"`
+}
+
+type Tag struct {
+	Tag            string `json:"tag"`
+	TranslatedName string `json:"translated_name,omitempty"`
+}
+
+// TagList /api/tags 的返回
+type TagList struct {
+	Tags []Tag `json:"tags"`
+}
+
+type ImageURLs struct {
+	SquareMedium string `json:"square_medium,omitempty"`
+	Medium       string `json:"medium,omitempty"`
+	Large        string `json:"large,omitempty"`
+	Original     string `json:"original,omitempty"`
+}
+
+// MetaSinglePage 单页作品的原图，多页作品为空，原图见 MetaPages
+type MetaSinglePage struct {
+	OriginalImageURL string `json:"original_image_url,omitempty"`
+}
+
+type MetaPage struct {
+	ImageURLs ImageURLs `json:"image_urls"`
+}
+
+type User struct {
+	ID               int64                 `json:"id"`
+	Name             string                `json:"name"`
+	ProfileImageURLs *ProfileImageURLs     `json:"profile_image_urls,omitempty"`
+	Premium          bool                  `json:"premium,omitempty"`
+	Background       *pixiv.UserBackground `json:"background,omitempty"`
+}
+
+type ProfileImageURLs struct {
+	Medium string `json:"medium,omitempty"`
+	Large  string `json:"large,omitempty"`
+}
+
+// IllustList 搜索、排行、用户作品等列表接口的返回
+type IllustList struct {
+	Illusts []Illust `json:"illusts"`
+	Length  int      `json:"length"`
+	NextURL string   `json:"next_url,omitempty"`
+	User    *User    `json:"user,omitempty"`
+}
+
+type IllustDetail struct {
+	Illust Illust `json:"illust"`
+	// SeriesContext 作品在漫画系列中的位置和前后作品
+	SeriesContext *IllustSeriesContext `json:"illust_series_context,omitempty"`
+}
+
+type IllustSeriesContext struct {
+	ContentOrder int               `json:"content_order"`
+	Prev         *IllustSeriesWork `json:"prev"`
+	Next         *IllustSeriesWork `json:"next"`
+}
+
+type IllustSeriesWork struct {
+	ID    int64  `json:"id"`
+	Title string `json:"title"`
+	Order int    `json:"order"`
+}
+
+type IllustSeriesDetail struct {
+	ID              int64     `json:"id"`
+	Title           string    `json:"title"`
+	Caption         string    `json:"caption"`
+	CoverImageURLs  ImageURLs `json:"cover_image_urls"`
+	SeriesWorkCount int       `json:"series_work_count"`
+	CreateDate      string    `json:"create_date,omitempty"`
+	User            User      `json:"user"`
+}
+
+// IllustSeriesList /api/series 的返回
+type IllustSeriesList struct {
+	IllustSeriesDetail IllustSeriesDetail `json:"illust_series_detail"`
+	IllustList
+}
+
+type UserPreviews struct {
+	UserPreviews []UserPreview `json:"user_previews"`
+}
+
+type UserPreview struct {
+	User User `json:"user"`
+}
+
+func newIllustList(illusts []Illust) *IllustList {
+	if illusts == nil {
+		illusts = []Illust{}
+	}
+	return &IllustList{Illusts: illusts, Length: len(illusts)}
+}
+
+func parseID(s string) int64 {
+	id, _ := strconv.ParseInt(s, 10, 64)
+	return id
+}
+
+func illustTypeName(t int) string {
+	switch t {
+	case pixiv.IllustTypeManga:
+		return "manga"
+	case pixiv.IllustTypeUgoira:
+		return "ugoira"
+	}
+	return "illust"
+}
+
+func tagsFromNames(names []string) []Tag {
+	tags := make([]Tag, 0, len(names))
+	for _, n := range names {
+		tags = append(tags, Tag{Tag: n})
+	}
+	return tags
+}
+
+// illustFromDetail 由作品详情生成，pages 为 nil 时只填写 meta_single_page
+func illustFromDetail(il *pixiv.Illust, pages []pixiv.Page) Illust {
+	tags := make([]Tag, 0, len(il.Tags.Tags))
+	for _, t := range il.Tags.Tags {
+		tags = append(tags, Tag{Tag: t.Tag})
+	}
+	ret := Illust{
+		ID:         parseID(il.ID),
+		Title:      il.Title,
+		Type:       illustTypeName(il.IllustType),
+		CreateDate: il.CreateDate,
+		User:       User{ID: parseID(il.UserID), Name: il.UserName},
+		Tags:       tags,
+		ImageURLs: ImageURLs{
+			SquareMedium: il.Urls.Thumb,
+			Medium:       il.Urls.Small,
+			Large:        il.Urls.Regular,
+		},
+		MetaPages:      []MetaPage{},
+		PageCount:      il.PageCount,
+		Width:          il.Width,
+		Height:         il.Height,
+		XRestrict:      il.XRestrict,
+		IllustAIType:   il.AiType,
+		TotalBookmarks: il.BookmarkCount,
+		TotalView:      il.ViewCount,
+	}
+	if nav := il.SeriesNavData; nav != nil && nav.SeriesID != "" {
+		ret.Series = &SeriesRef{ID: parseID(nav.SeriesID), Title: nav.Title}
+	}
+	if pages == nil {
+		ret.MetaSinglePage.OriginalImageURL = il.Urls.Original
+		return ret
+	}
+	for _, p := range pages {
+		ret.MetaPages = append(ret.MetaPages, MetaPage{ImageURLs: ImageURLs{
+			SquareMedium: p.Urls.ThumbMini,
+			Medium:       p.Urls.Small,
+			Large:        p.Urls.Regular,
+			Original:     p.Urls.Original,
+		}})
+	}
+	return ret
+}
+
+// illustFromBrief 列表中只有缩略图，放在 image_urls.large，meta_single_page 留空，原图需要再请求详情
+func illustFromBrief(b *pixiv.IllustBrief) Illust {
+	return Illust{
+		ID:           parseID(b.ID),
+		Title:        b.Title,
+		Type:         illustTypeName(b.IllustType),
+		CreateDate:   b.CreateDate,
+		User:         User{ID: parseID(b.UserID), Name: b.UserName},
+		Tags:         tagsFromNames(b.Tags),
+		ImageURLs:    ImageURLs{Large: b.URL},
+		MetaPages:    []MetaPage{},
+		PageCount:    b.PageCount,
+		Width:        b.Width,
+		Height:       b.Height,
+		XRestrict:    b.XRestrict,
+		IllustAIType: b.AiType,
+	}
+}
+
+func illustFromRanking(r *pixiv.RankingItem) Illust {
+	illustType, _ := strconv.Atoi(r.IllustType)
+	pageCount, _ := strconv.Atoi(r.IllustPageCount)
+	var createDate string
+	if r.IllustUploadTime > 0 {
+		createDate = time.Unix(r.IllustUploadTime, 0).In(jst).Format(time.RFC3339)
+	}
+	return Illust{
+		ID:         r.IllustID,
+		Title:      r.Title,
+		Type:       illustTypeName(illustType),
+		CreateDate: createDate,
+		User:       User{ID: r.UserID, Name: r.UserName, ProfileImageURLs: &ProfileImageURLs{Medium: r.ProfileImg}},
+		Tags:       tagsFromNames(r.Tags),
+		ImageURLs:  ImageURLs{Large: r.URL},
+		MetaPages:  []MetaPage{},
+		PageCount:  pageCount,
+		Width:      r.Width,
+		Height:     r.Height,
+		XRestrict:  r.IllustContentType.Sexual,
+		TotalView:  int64(r.ViewCount),
+	}
+}
+
+// seriesContextFromNav 作品在系列中的位置，不属于系列时返回 nil
+func seriesContextFromNav(nav *pixiv.IllustSeriesNav) *IllustSeriesContext {
+	if nav == nil || nav.SeriesID == "" {
+		return nil
+	}
+	link := func(l *pixiv.IllustSeriesNavLink) *IllustSeriesWork {
+		if l == nil {
+			return nil
+		}
+		return &IllustSeriesWork{ID: parseID(l.ID), Title: l.Title, Order: l.Order}
+	}
+	return &IllustSeriesContext{ContentOrder: nav.Order, Prev: link(nav.Prev), Next: link(nav.Next)}
+}
+
+func userFromPixiv(u *pixiv.User) User {
+	return User{
+		ID:   parseID(u.UserID),
+		Name: u.Name,
+		ProfileImageURLs: &ProfileImageURLs{
+			Medium: u.Image,
+			Large:  u.ImageBig,
+		},
+		Premium:    u.Premium,
+		Background: u.Background,
+	}
+}
+
+type Novel struct {
+	ID             int64           `json:"id"`
+	Title          string          `json:"title"`
+	Caption        string          `json:"caption"`
+	CreateDate     string          `json:"create_date,omitempty"`
+	User           User            `json:"user"`
+	Tags           []Tag           `json:"tags"`
+	ImageURLs      ImageURLs       `json:"image_urls"`
+	IsOriginal     bool            `json:"is_original"`
+	XRestrict      int             `json:"x_restrict"`
+	NovelAIType    int             `json:"novel_ai_type"`
+	TextLength     int             `json:"text_length"`
+	TotalBookmarks int64           `json:"total_bookmarks"`
+	TotalView      int64           `json:"total_view"`
+	Series         *NovelSeriesRef `json:"series,omitempty"`
+	// Filtered 为 true 表示该项是被内容过滤替换的占位项
+	Filtered bool `json:"filtered,omitempty"`
+}
+
+type NovelSeriesRef struct {
+	ID    int64  `json:"id"`
+	Title string `json:"title"`
+}
+
+// NovelDetail /api/novel 的返回，text 为去掉标记后的正文
+type NovelDetail struct {
+	Novel Novel  `json:"novel"`
+	Text  string `json:"text"`
+}
+
+type NovelList struct {
+	Novels  []Novel `json:"novels"`
+	Length  int     `json:"length"`
+	NextURL string  `json:"next_url,omitempty"`
+}
+
+type NovelSeriesDetail struct {
+	ID           int64     `json:"id"`
+	Title        string    `json:"title"`
+	Caption      string    `json:"caption"`
+	IsOriginal   bool      `json:"is_original"`
+	XRestrict    int       `json:"x_restrict"`
+	NovelAIType  int       `json:"novel_ai_type"`
+	ContentCount int       `json:"content_count"`
+	CreateDate   string    `json:"create_date,omitempty"`
+	User         User      `json:"user"`
+	Tags         []Tag     `json:"tags"`
+	ImageURLs    ImageURLs `json:"image_urls"`
+}
+
+// NovelSeriesList /api/novel_series 的返回
+type NovelSeriesList struct {
+	NovelSeriesDetail NovelSeriesDetail `json:"novel_series_detail"`
+	NovelList
+}
+
+func newNovelList(novels []Novel) NovelList {
+	if novels == nil {
+		novels = []Novel{}
+	}
+	return NovelList{Novels: novels, Length: len(novels)}
+}
+
+// novelFromDetail 由小说详情生成，封面只有一种尺寸
+func novelFromDetail(n *pixiv.Novel) Novel {
+	tags := make([]Tag, 0, len(n.Tags.Tags))
+	for _, t := range n.Tags.Tags {
+		tags = append(tags, Tag{Tag: t.Tag})
+	}
+	ret := Novel{
+		ID:             parseID(n.ID),
+		Title:          n.Title,
+		Caption:        n.Description,
+		CreateDate:     n.CreateDate,
+		User:           User{ID: parseID(n.UserID), Name: n.UserName},
+		Tags:           tags,
+		ImageURLs:      ImageURLs{Medium: n.CoverURL},
+		IsOriginal:     n.IsOriginal,
+		XRestrict:      n.XRestrict,
+		NovelAIType:    n.AiType,
+		TextLength:     n.CharacterCount,
+		TotalBookmarks: n.BookmarkCount,
+		TotalView:      n.ViewCount,
+	}
+	if nav := n.SeriesNavData; nav != nil {
+		ret.Series = &NovelSeriesRef{ID: nav.SeriesID, Title: nav.Title}
+	}
+	return ret
+}
+
+func novelFromBrief(b *pixiv.NovelBrief) Novel {
+	ret := Novel{
+		ID:             parseID(b.ID),
+		Title:          b.Title,
+		Caption:        b.Description,
+		CreateDate:     b.CreateDate,
+		User:           User{ID: parseID(b.UserID), Name: b.UserName},
+		Tags:           tagsFromNames(b.Tags),
+		ImageURLs:      ImageURLs{Medium: b.URL},
+		IsOriginal:     b.IsOriginal,
+		XRestrict:      b.XRestrict,
+		NovelAIType:    b.AiType,
+		TextLength:     b.CharacterCount,
+		TotalBookmarks: b.BookmarkCount,
+	}
+	if ret.TextLength == 0 {
+		ret.TextLength = b.TextCount
+	}
+	if b.SeriesID != "" {
+		ret.Series = &NovelSeriesRef{ID: parseID(b.SeriesID), Title: b.SeriesTitle}
+	}
+	return ret
+}
+
+func novelSeriesFromPixiv(s *pixiv.NovelSeries) NovelSeriesDetail {
+	return NovelSeriesDetail{
+		ID:           parseID(s.ID),
+		Title:        s.Title,
+		Caption:      s.Caption,
+		IsOriginal:   s.IsOriginal,
+		XRestrict:    s.XRestrict,
+		NovelAIType:  s.AiType,
+		ContentCount: s.PublishedContentCount,
+		CreateDate:   s.CreateDate,
+		User:         User{ID: parseID(s.UserID), Name: s.UserName},
+		Tags:         tagsFromNames(s.Tags),
+		ImageURLs: ImageURLs{
+			Medium: s.Cover.Urls["480mw"],
+			Large:  s.Cover.Urls["1200x1200"],
+		},
+	}
+}
@@ -0,0 +1,528 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"go-pixiv-proxy/pixiv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// novelPageSize /api/novel_series 和 /api/user_novels 每页的数量，与 pixiv 系列目录一次最多返回的数量相同
+const novelPageSize = 30
+
+// novelMarkupRe pixiv 小说正文中的标记
+var novelMarkupRe = regexp.MustCompile(`\[newpage\]|\[chapter:(.*?)\]|\[\[rb:(.*?)>(.*?)\]\]|\[\[jumpuri:(.*?)>(.*?)\]\]|\[jump:(\d+)\]|\[pixivimage:(\d+)(?:-(\d+))?\]|\[uploadedimage:(\d+)\]`)
+
+const (
+	novelText = iota
+	novelNewPage
+	novelChapter
+	novelRuby
+	novelLink
+	novelJump
+	novelPixivImage
+	novelUploadedImage
+)
+
+// novelNode 正文解析后的片段
+type novelNode struct {
+	kind int
+	// text 文本、章节标题、注音的正文或链接文字
+	text string
+	// extra 注音或链接地址
+	extra string
+	// id 插画 id 或上传图片 id
+	id string
+	// page 页码，插画从 1 开始，跳转为目标页
+	page int
+}
+
+func parseNovelText(content string) []novelNode {
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	var nodes []novelNode
+	last := 0
+	for _, m := range novelMarkupRe.FindAllStringSubmatchIndex(content, -1) {
+		if m[0] > last {
+			nodes = append(nodes, novelNode{kind: novelText, text: content[last:m[0]]})
+		}
+		last = m[1]
+		group := func(i int) string {
+			if m[2*i] < 0 {
+				return ""
+			}
+			return strings.TrimSpace(content[m[2*i]:m[2*i+1]])
+		}
+		switch {
+		case m[2] >= 0:
+			nodes = append(nodes, novelNode{kind: novelChapter, text: group(1)})
+		case m[4] >= 0:
+			nodes = append(nodes, novelNode{kind: novelRuby, text: group(2), extra: group(3)})
+		case m[8] >= 0:
+			if u, ok := novelLinkURL(group(5)); ok {
+				nodes = append(nodes, novelNode{kind: novelLink, text: group(4), extra: u})
+			} else {
+				// 不是 http(s) 链接时只保留文字
+				nodes = append(nodes, novelNode{kind: novelText, text: group(4)})
+			}
+		case m[12] >= 0:
+			page, _ := strconv.Atoi(group(6))
+			nodes = append(nodes, novelNode{kind: novelJump, page: page})
+		case m[14] >= 0:
+			page, _ := strconv.Atoi(group(8))
+			if page < 1 {
+				page = 1
+			}
+			nodes = append(nodes, novelNode{kind: novelPixivImage, id: group(7), page: page})
+		case m[18] >= 0:
+			nodes = append(nodes, novelNode{kind: novelUploadedImage, id: group(9)})
+		default:
+			nodes = append(nodes, novelNode{kind: novelNewPage})
+		}
+	}
+	if last < len(content) {
+		nodes = append(nodes, novelNode{kind: novelText, text: content[last:]})
+	}
+	// 换页、章节和插图单独占一行，去掉它们前后的一个换行
+	for i := range nodes {
+		if !nodes[i].block() {
+			continue
+		}
+		if i > 0 && nodes[i-1].kind == novelText {
+			nodes[i-1].text = strings.TrimSuffix(nodes[i-1].text, "\n")
+		}
+		if i+1 < len(nodes) && nodes[i+1].kind == novelText {
+			nodes[i+1].text = strings.TrimPrefix(nodes[i+1].text, "\n")
+		}
+	}
+	return nodes
+}
+
+func (n *novelNode) block() bool {
+	return n.kind == novelNewPage || n.kind == novelChapter || n.kind == novelPixivImage || n.kind == novelUploadedImage
+}
+
+// novelLinkURL 校验 [[jumpuri:]] 的地址，只允许 http 和 https，返回规范化后的地址
+func novelLinkURL(s string) (string, bool) {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", false
+	}
+	return u.String(), true
+}
+
+// novelImageURL 正文插图的代理地址，上传的插图无法代理时返回原地址
+func (c *Context) novelImageURL(n *pixiv.Novel, node novelNode) string {
+	if node.kind == novelPixivImage {
+		if node.page > 1 {
+			return c.proxyURL("/" + node.id + "/" + strconv.Itoa(node.page-1) + "?t=regular")
+		}
+		return c.proxyURL("/" + node.id + "?t=regular")
+	}
+	return c.proxyImageURL(n.TextEmbedded[node.id].Urls["original"])
+}
+
+// renderNovelPlain 去掉标记的纯文本，注音写在括号中，图片为单独一行的地址
+func renderNovelPlain(nodes []novelNode, imageURL func(novelNode) string) string {
+	var b strings.Builder
+	for _, n := range nodes {
+		switch n.kind {
+		case novelText:
+			b.WriteString(n.text)
+		case novelNewPage:
+			b.WriteString("\n\n")
+		case novelChapter:
+			b.WriteString("\n" + n.text + "\n")
+		case novelRuby:
+			b.WriteString(n.text + "（" + n.extra + "）")
+		case novelLink:
+			b.WriteString(n.text + " (" + n.extra + ")")
+		case novelPixivImage, novelUploadedImage:
+			b.WriteString("\n" + imageURL(n) + "\n")
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+var markdownEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`, "<", "&lt;", ">", "&gt;", "`", "\\`")
+
+// markdownLinkEscaper 转义 <...> 形式的链接地址中不能出现的字符
+var markdownLinkEscaper = strings.NewReplacer("<", "%3C", ">", "%3E", `\`, "%5C", "\n", "", "\r", "")
+
+// renderNovelMarkdown 换页为分隔线，章节为二级标题，注音使用 <ruby>
+func renderNovelMarkdown(title string, nodes []novelNode, imageURL func(novelNode) string) string {
+	var b strings.Builder
+	b.WriteString("# " + markdownEscaper.Replace(title) + "\n\n")
+	for _, n := range nodes {
+		switch n.kind {
+		case novelText:
+			// 正文依赖换行排版，使用硬换行
+			b.WriteString(strings.ReplaceAll(markdownEscaper.Replace(n.text), "\n", "  \n"))
+		case novelNewPage:
+			b.WriteString("\n\n---\n\n")
+		case novelChapter:
+			b.WriteString("\n\n## " + markdownEscaper.Replace(n.text) + "\n\n")
+		case novelRuby:
+			b.WriteString("<ruby>" + html.EscapeString(n.text) + "<rp>（</rp><rt>" + html.EscapeString(n.extra) + "</rt><rp>）</rp></ruby>")
+		case novelLink:
+			b.WriteString("[" + markdownEscaper.Replace(n.text) + "](<" + markdownLinkEscaper.Replace(n.extra) + ">)")
+		case novelPixivImage, novelUploadedImage:
+			b.WriteString("\n\n![](<" + markdownLinkEscaper.Replace(imageURL(n)) + ">)\n\n")
+		}
+	}
+	return strings.TrimSpace(b.String()) + "\n"
+}
+
+// renderNovelXHTML 按 [newpage] 分页，返回每页 <body> 中的内容，prefix 为各页文件名的前缀
+func renderNovelXHTML(nodes []novelNode, prefix string, imageSrc func(novelNode) string) []string {
+	var (
+		pages []string
+		page  strings.Builder
+		line  strings.Builder
+	)
+	flush := func() {
+		if line.Len() == 0 {
+			page.WriteString("<p><br/></p>\n")
+		} else {
+			page.WriteString("<p>" + line.String() + "</p>\n")
+		}
+		line.Reset()
+	}
+	for _, n := range nodes {
+		switch n.kind {
+		case novelText:
+			lines := strings.Split(n.text, "\n")
+			for i, l := range lines {
+				if i > 0 {
+					flush()
+				}
+				line.WriteString(html.EscapeString(l))
+			}
+		case novelNewPage:
+			if line.Len() > 0 {
+				flush()
+			}
+			pages = append(pages, page.String())
+			page.Reset()
+		case novelChapter:
+			if line.Len() > 0 {
+				flush()
+			}
+			page.WriteString("<h2>" + html.EscapeString(n.text) + "</h2>\n")
+		case novelRuby:
+			line.WriteString("<ruby>" + html.EscapeString(n.text) + "<rt>" + html.EscapeString(n.extra) + "</rt></ruby>")
+		case novelLink:
+			line.WriteString(`<a href="` + html.EscapeString(n.extra) + `">` + html.EscapeString(n.text) + "</a>")
+		case novelJump:
+			line.WriteString(`<a href="` + prefix + `page-` + strconv.Itoa(n.page) + `.xhtml">` + strconv.Itoa(n.page) + "</a>")
+		case novelPixivImage, novelUploadedImage:
+			if line.Len() > 0 {
+				flush()
+			}
+			if src := imageSrc(n); src != "" {
+				page.WriteString(`<p class="image"><img src="` + html.EscapeString(src) + `" alt=""/></p>` + "\n")
+			}
+		}
+	}
+	if line.Len() > 0 {
+		flush()
+	}
+	return append(pages, page.String())
+}
+
+// novelImageSource 正文插图在 pixiv 上的地址，用于打包到 EPUB
+func novelImageSource(ctx context.Context, n *pixiv.Novel, node novelNode) (string, error) {
+	if node.kind == novelUploadedImage {
+		urls := n.TextEmbedded[node.id].Urls
+		if u := urls["1200x1200"]; u != "" {
+			return u, nil
+		}
+		if u := urls["original"]; u != "" {
+			return u, nil
+		}
+		return "", fmt.Errorf("uploaded image %s not found", node.id)
+	}
+	illust, err := pixivClient().Illust(ctx, node.id)
+	if err != nil {
+		return "", err
+	}
+	if illust.Urls.Regular == "" {
+		return "", fmt.Errorf("illust %s needs login", node.id)
+	}
+	return strings.Replace(illust.Urls.Regular, "_p0", "_p"+strconv.Itoa(node.page-1), 1), nil
+}
+
+// maxEpubImageSize EPUB 中单张图片的最大字节数
+const maxEpubImageSize = 32 << 20
+
+// fetchEpubImage 下载图片用于打包，超过 maxEpubImageSize 时返回错误
+func fetchEpubImage(ctx context.Context, u, name string) (*epubImage, error) {
+	resp, err := pixivClient().Get(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &pixiv.StatusError{URL: u, StatusCode: resp.StatusCode}
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxEpubImageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxEpubImageSize {
+		return nil, fmt.Errorf("image %s exceeds %d bytes", u, maxEpubImageSize)
+	}
+	mediaType := mime.TypeByExtension(path.Ext(u))
+	if mediaType == "" {
+		mediaType = http.DetectContentType(b)
+	}
+	return &epubImage{Name: name + path.Ext(u), MediaType: mediaType, Data: b}, nil
+}
+
+// addNovelCover 下载封面作为 EPUB 的封面，失败时没有封面
+func (book *epubBook) addNovelCover(ctx context.Context, u string) error {
+	if u == "" {
+		return nil
+	}
+	img, err := fetchEpubImage(ctx, u, "cover")
+	if err != nil {
+		log.Warnln("fetch novel cover:", err)
+		return nil
+	}
+	return book.addCover(img)
+}
+
+// novelEpub 由小说生成 EPUB，插图和封面打包在文件中，下载失败的插图会被跳过
+func novelEpub(ctx context.Context, n *pixiv.Novel) func(book *epubBook) error {
+	return func(book *epubBook) error {
+		book.Identifier = "https://www.pixiv.net/novel/show.php?id=" + n.ID
+		book.Title = n.Title
+		book.Author = n.UserName
+		book.Language = n.Language
+		book.Description = plainDescription(n.Description)
+		book.Modified = parseDate(n.UploadDate)
+		if err := book.addNovelCover(ctx, n.CoverURL); err != nil {
+			return err
+		}
+		return book.addNovel(ctx, n, "")
+	}
+}
+
+// novelSeriesEpub 按系列中的顺序把各篇小说打包为一个 EPUB，被过滤的小说会被跳过
+func (c *Context) novelSeriesEpub(series *pixiv.NovelSeries) func(book *epubBook) error {
+	ctx := c.req.Context()
+	return func(book *epubBook) error {
+		book.Identifier = "https://www.pixiv.net/novel/series/" + series.ID
+		book.Title = series.Title
+		book.Author = series.UserName
+		book.Language = series.Language
+		book.Description = plainDescription(series.Caption)
+		book.Modified = parseDate(series.UpdateDate)
+		cover := series.Cover.Urls["1200x1200"]
+		if cover == "" {
+			cover = series.Cover.Urls["480mw"]
+		}
+		if err := book.addNovelCover(ctx, cover); err != nil {
+			return err
+		}
+		for order := 0; order < series.PublishedContentCount; order += novelPageSize {
+			contents, err := pixivClient().NovelSeriesContent(ctx, series.ID, order, novelPageSize)
+			if err != nil {
+				return err
+			}
+			for _, brief := range contents {
+				n, err := pixivClient().Novel(ctx, brief.ID)
+				var apiErr *pixiv.APIError
+				if errors.As(err, &apiErr) {
+					log.Warnf("novel series %s: novel %s: %v", series.ID, brief.ID, err)
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				if c.filter().blocked(n.XRestrict, n.AiType, novelTagNames(n)) {
+					continue
+				}
+				if err := book.addNovel(ctx, n, "n"+n.ID+"-"); err != nil {
+					return err
+				}
+			}
+			if len(contents) < novelPageSize {
+				break
+			}
+		}
+		return nil
+	}
+}
+
+// addNovel 把一篇小说的插图和各页写入 book，prefix 用于区分系列中的不同小说
+func (book *epubBook) addNovel(ctx context.Context, n *pixiv.Novel, prefix string) error {
+	images := map[string]string{}
+	var writeErr error
+	imageSrc := func(node novelNode) string {
+		key := strconv.Itoa(node.kind) + "-" + node.id + "-" + strconv.Itoa(node.page)
+		if src, ok := images[key]; ok || writeErr != nil {
+			return src
+		}
+		src := ""
+		if u, err := novelImageSource(ctx, n, node); err != nil {
+			log.Warnln("novel image:", err)
+		} else if img, err := fetchEpubImage(ctx, u, prefix+"img-"+strconv.Itoa(len(images))); err != nil {
+			log.Warnln("novel image:", err)
+		} else if writeErr = book.addImage(img); writeErr == nil {
+			src = "../images/" + img.Name
+		}
+		images[key] = src
+		return src
+	}
+	pages := renderNovelXHTML(parseNovelText(n.Content), prefix, imageSrc)
+	if writeErr != nil {
+		return writeErr
+	}
+	for i, body := range pages {
+		title := n.Title
+		if i > 0 {
+			title += " (" + strconv.Itoa(i+1) + ")"
+		}
+		if i == 0 {
+			body = "<h1>" + html.EscapeString(n.Title) + "</h1>\n" + body
+		}
+		err := book.addSection(epubSection{
+			Name:  prefix + "page-" + strconv.Itoa(i+1),
+			Title: title,
+			Body:  body,
+			// 目录中只列出每篇小说的第一页
+			Hidden: i > 0,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func novelTagNames(n *pixiv.Novel) []string {
+	tags := make([]string, 0, len(n.Tags.Tags))
+	for _, t := range n.Tags.Tags {
+		tags = append(tags, t.Tag)
+	}
+	return tags
+}
+
+// handleApiNovel 小说详情和正文，format 为 json（默认）、txt、md 或 epub
+func handleApiNovel(c *Context) {
+	query := c.req.URL.Query()
+	id := query.Get("id")
+	if !isDigits(id) {
+		c.Error(400, "id invalid")
+		return
+	}
+	format := query.Get("format")
+	if format != "" && format != "json" && format != "txt" && format != "md" && format != "epub" {
+		c.Error(400, "format invalid")
+		return
+	}
+	n, err := pixivClient().Novel(c.req.Context(), id)
+	if err != nil {
+		c.PixivError(err)
+		return
+	}
+	if c.filter().blocked(n.XRestrict, n.AiType, novelTagNames(n)) {
+		c.Error(http.StatusUnavailableForLegalReasons, "this work is filtered")
+		return
+	}
+	imageURL := func(node novelNode) string { return c.novelImageURL(n, node) }
+	switch format {
+	case "txt":
+		c.rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		c.writeCacheable([]byte(n.Title + "\n\n" + renderNovelPlain(parseNovelText(n.Content), imageURL) + "\n"))
+	case "md":
+		c.rw.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		c.writeCacheable([]byte(renderNovelMarkdown(n.Title, parseNovelText(n.Content), imageURL)))
+	case "epub":
+		c.streamEpub(n.Title, novelEpub(c.req.Context(), n))
+	default:
+		c.JSON(200, &NovelDetail{
+			Novel: novelFromDetail(n),
+			Text:  renderNovelPlain(parseNovelText(n.Content), imageURL),
+		})
+	}
+}
+
+func handleApiNovelSeries(c *Context) {
+	query := c.req.URL.Query()
+	id := query.Get("id")
+	page, ok := parsePage(query)
+	if !isDigits(id) || !ok {
+		c.Error(400, "id or page invalid")
+		return
+	}
+	series, err := pixivClient().NovelSeries(c.req.Context(), id)
+	if err != nil {
+		c.PixivError(err)
+		return
+	}
+	contents, err := pixivClient().NovelSeriesContent(c.req.Context(), id, (page-1)*novelPageSize, novelPageSize)
+	if err != nil {
+		c.PixivError(err)
+		return
+	}
+	novels := make([]Novel, 0, len(contents))
+	for i := range contents {
+		novels = append(novels, novelFromBrief(&contents[i]))
+	}
+	ret := &NovelSeriesList{
+		NovelSeriesDetail: novelSeriesFromPixiv(series),
+		NovelList:         newNovelList(novels),
+	}
+	if page*novelPageSize < series.PublishedContentCount {
+		ret.NextURL = "id=" + id + "&page=" + strconv.Itoa(page+1)
+	}
+	c.filterNovels(&ret.NovelList)
+	c.JSON(200, ret)
+}
+
+func handleApiUserNovels(c *Context) {
+	query := c.req.URL.Query()
+	uid := query.Get("id")
+	page, ok := parsePage(query)
+	if !isDigits(uid) || !ok {
+		c.Error(400, "id or page invalid")
+		return
+	}
+	all, err := pixivClient().UserProfileAll(c.req.Context(), uid)
+	if err != nil {
+		c.PixivError(err)
+		return
+	}
+	ids := all.Novels.Sorted()
+	start, end := getPageRange(page, novelPageSize, len(ids))
+	works, err := pixivClient().UserNovels(c.req.Context(), uid, ids[start:end])
+	if err != nil {
+		c.PixivError(err)
+		return
+	}
+	novels := make([]Novel, 0, end-start)
+	for _, id := range ids[start:end] {
+		if w, ok := works[id]; ok {
+			novels = append(novels, novelFromBrief(&w))
+		}
+	}
+	ret := newNovelList(novels)
+	if end < len(ids) {
+		ret.NextURL = "id=" + uid + "&page=" + strconv.Itoa(page+1)
+	}
+	c.filterNovels(&ret)
+	c.JSON(200, &ret)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNovelLinks(t *testing.T) {
+	noImage := func(novelNode) string { return "" }
+	tests := []struct {
+		text string
+		// markdown、xhtml 中应出现的内容，为空表示不应生成链接
+		markdown, xhtml string
+	}{
+		{
+			"[[jumpuri:site > https://example.com/a?b=1&c=2]]",
+			"[site](<https://example.com/a?b=1&c=2>)",
+			`<a href="https://example.com/a?b=1&amp;c=2">site</a>`,
+		},
+		{
+			"[[jumpuri:x > https://example.com/a>b)c]]",
+			"[x](<https://example.com/a%3Eb%29c>)",
+			`<a href="https://example.com/a%3Eb%29c">x</a>`,
+		},
+		{
+			`[[jumpuri:q > https://example.com/"onclick="x]]`,
+			"[q](<https://example.com/%22onclick=%22x>)",
+			`<a href="https://example.com/%22onclick=%22x">q</a>`,
+		},
+		{
+			"[[jumpuri:q > https://example.com/?a=<b>)]]",
+			"[q](<https://example.com/?a=%3Cb%3E)>)",
+			`<a href="https://example.com/?a=&lt;b&gt;)">q</a>`,
+		},
+		{"[[jumpuri:js > javascript:alert(1)]]", "", ""},
+		{"[[jumpuri:data > data:text/html,x]]", "", ""},
+		{"[[jumpuri:rel > /relative]]", "", ""},
+		{"[[jumpuri:proto > //example.com]]", "", ""},
+	}
+	for _, tt := range tests {
+		nodes := parseNovelText(tt.text)
+		md := renderNovelMarkdown("t", nodes, noImage)
+		xhtml := strings.Join(renderNovelXHTML(nodes, "", noImage), "")
+		if tt.markdown == "" {
+			if strings.Contains(md, "](") || strings.Contains(xhtml, "<a ") {
+				t.Errorf("%s: link rendered:\n%s\n%s", tt.text, md, xhtml)
+			}
+			continue
+		}
+		if !strings.Contains(md, tt.markdown) {
+			t.Errorf("%s: markdown %q does not contain %q", tt.text, md, tt.markdown)
+		}
+		if !strings.Contains(xhtml, tt.xhtml) {
+			t.Errorf("%s: xhtml %q does not contain %q", tt.text, xhtml, tt.xhtml)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// oembedVariant pid 图片的一种尺寸，square 为裁剪成正方形的缩略图
+type oembedVariant struct {
+	name   string
+	size   int
+	square bool
+}
+
+// oembedVariants 从大到小排列，按 maxwidth / maxheight 选择第一个放得下的
+var oembedVariants = []oembedVariant{
+	{"regular", 1200, false},
+	{"small", 540, false},
+	{"thumb", 250, true},
+	{"mini", 48, true},
+}
+
+var (
+	pixivArtworkRe = regexp.MustCompile(`^/(?:[a-z]{2}/)?artworks/(\d+)$`)
+	proxyIllustRe  = regexp.MustCompile(`^/(?:i/)?(\d+)(?:/(\d+))?$`)
+)
+
+type oembedResponse struct {
+	XMLName         xml.Name `json:"-" xml:"oembed"`
+	Type            string   `json:"type" xml:"type"`
+	Version         string   `json:"version" xml:"version"`
+	Title           string   `json:"title" xml:"title"`
+	AuthorName      string   `json:"author_name" xml:"author_name"`
+	AuthorURL       string   `json:"author_url" xml:"author_url"`
+	ProviderName    string   `json:"provider_name" xml:"provider_name"`
+	ProviderURL     string   `json:"provider_url" xml:"provider_url"`
+	URL             string   `json:"url" xml:"url"`
+	Width           int      `json:"width" xml:"width"`
+	Height          int      `json:"height" xml:"height"`
+	ThumbnailURL    string   `json:"thumbnail_url" xml:"thumbnail_url"`
+	ThumbnailWidth  int      `json:"thumbnail_width" xml:"thumbnail_width"`
+	ThumbnailHeight int      `json:"thumbnail_height" xml:"thumbnail_height"`
+}
+
+// fit 按变体的缩放规则计算尺寸
+func (v oembedVariant) fit(w, h int) (int, int) {
+	if v.square || w <= 0 || h <= 0 {
+		return v.size, v.size
+	}
+	if w <= v.size && h <= v.size {
+		return w, h
+	}
+	if w >= h {
+		return v.size, h * v.size / w
+	}
+	return w * v.size / h, v.size
+}
+
+// pickVariant 选择不超过 maxWidth / maxHeight（0 表示不限制）的最大变体，都放不下时返回最小的
+func pickVariant(w, h, maxWidth, maxHeight int) (oembedVariant, int, int) {
+	for _, v := range oembedVariants {
+		vw, vh := v.fit(w, h)
+		if (maxWidth <= 0 || vw <= maxWidth) && (maxHeight <= 0 || vh <= maxHeight) {
+			return v, vw, vh
+		}
+	}
+	v := oembedVariants[len(oembedVariants)-1]
+	vw, vh := v.fit(w, h)
+	return v, vw, vh
+}
+
+// parseOEmbedURL 从 pixiv 作品页或本代理的 pid 地址中取出作品 id 和页码
+func (c *Context) parseOEmbedURL(raw string) (pid string, page int, ok bool) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", 0, false
+	}
+	host := strings.ToLower(u.Host)
+	if host == "pixiv.net" || host == "www.pixiv.net" {
+		if m := pixivArtworkRe.FindStringSubmatch(u.Path); m != nil {
+			return m[1], 0, true
+		}
+		return "", 0, false
+	}
+	self, err := url.Parse(c.baseURL())
+	if err != nil || !strings.EqualFold(self.Host, u.Host) {
+		return "", 0, false
+	}
+	m := proxyIllustRe.FindStringSubmatch(u.Path)
+	if m == nil {
+		return "", 0, false
+	}
+	page, _ = strconv.Atoi(m[2])
+	return m[1], page, true
+}
+
+// handleOEmbed oEmbed 接口：/oembed?url=https://www.pixiv.net/artworks/98505703&format=json&maxwidth=600
+func handleOEmbed(c *Context) {
+	c.cache = cacheAPI
+	query := c.req.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "xml" {
+		c.Error(http.StatusNotImplemented, "format not supported")
+		return
+	}
+	pid, page, ok := c.parseOEmbedURL(query.Get("url"))
+	if !ok {
+		c.Error(404, "url not supported")
+		return
+	}
+	maxWidth, _ := strconv.Atoi(query.Get("maxwidth"))
+	maxHeight, _ := strconv.Atoi(query.Get("maxheight"))
+
+	illust, err := pixivClient().Illust(c.req.Context(), pid)
+	if err != nil {
+		c.PixivError(err)
+		return
+	}
+	if c.blockedWork(illust) {
+		c.Error(http.StatusUnavailableForLegalReasons, "this work is filtered")
+		return
+	}
+	if page >= illust.PageCount && page > 0 {
+		c.Error(404, "page not found")
+		return
+	}
+	w, h := illust.Width, illust.Height
+	imgPath := "/" + pid
+	if page > 0 {
+		pages, err := pixivClient().Pages(c.req.Context(), pid)
+		if err != nil {
+			c.PixivError(err)
+			return
+		}
+		if page < len(pages) {
+			w, h = pages[page].Width, pages[page].Height
+		}
+		imgPath += "/" + strconv.Itoa(page)
+	}
+	v, vw, vh := pickVariant(w, h, maxWidth, maxHeight)
+	thumb := oembedVariants[2]
+	ret := &oembedResponse{
+		Type:            "photo",
+		Version:         "1.0",
+		Title:           illust.Title,
+		AuthorName:      illust.UserName,
+		AuthorURL:       "https://www.pixiv.net/users/" + illust.UserID,
+		ProviderName:    "pixiv",
+		ProviderURL:     "https://www.pixiv.net",
+		URL:             c.proxyURL(imgPath + "?t=" + v.name),
+		Width:           vw,
+		Height:          vh,
+		ThumbnailURL:    c.proxyURL(imgPath + "?t=" + thumb.name),
+		ThumbnailWidth:  thumb.size,
+		ThumbnailHeight: thumb.size,
+	}
+	if format == "json" {
+		c.JSON(200, ret)
+		return
+	}
+	b, err := xml.Marshal(ret)
+	if err != nil {
+		c.Error(500, err.Error())
+		return
+	}
+	c.rw.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	c.writeCacheable(append([]byte(xml.Header), b...))
+}
@@ -0,0 +1,163 @@
+// Package pixiv 是 pixiv web ajax 接口的简单客户端，
+// 供代理服务和其他需要读取 pixiv 数据的工具共用
+package pixiv
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+const (
+	DefaultBaseURL      = "https://www.pixiv.net"
+	DefaultImageBaseURL = "https://i.pximg.net"
+	// DefaultStaticBaseURL 表情、贴图和默认头像等静态图片
+	DefaultStaticBaseURL = "https://s.pximg.net"
+	// DefaultEmbedBaseURL 分享用的 OGP 图片
+	DefaultEmbedBaseURL = "https://embed.pixiv.net"
+	DefaultReferer      = "https://www.pixiv.net"
+	DefaultUserAgent    = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/81.0.4044.113 Safari/537.36"
+)
+
+// Client pixiv 客户端，字段在创建后不应再修改，需要更换 cookie 时创建新的 Client
+type Client struct {
+	HTTPClient    *http.Client
+	BaseURL       string
+	ImageBaseURL  string
+	StaticBaseURL string
+	EmbedBaseURL  string
+	Cookies       string
+	UserAgent     string
+	Referer       string
+}
+
+// NewClient 返回使用默认地址和请求头的客户端，httpClient 为 nil 时使用 http.DefaultClient
+func NewClient(httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{
+		HTTPClient:    httpClient,
+		BaseURL:       DefaultBaseURL,
+		ImageBaseURL:  DefaultImageBaseURL,
+		StaticBaseURL: DefaultStaticBaseURL,
+		EmbedBaseURL:  DefaultEmbedBaseURL,
+		UserAgent:     DefaultUserAgent,
+		Referer:       DefaultReferer,
+	}
+}
+
+// Get 携带 Referer、User-Agent 和 cookie 请求任意 pixiv 地址，调用方负责关闭 Body
+func (c *Client) Get(ctx context.Context, u string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Referer", c.Referer)
+	req.Header.Set("User-Agent", c.UserAgent)
+	if c.Cookies != "" {
+		req.Header.Set("Cookie", c.Cookies)
+	}
+	return c.HTTPClient.Do(req)
+}
+
+// Image 请求 ImageBaseURL 下的图片，path 需以 / 开头
+func (c *Client) Image(ctx context.Context, path string) (*http.Response, error) {
+	return c.Get(ctx, c.ImageBaseURL+path)
+}
+
+// getBytes 请求 BaseURL 下的路径，非 2xx 时返回 *StatusError
+func (c *Client) getBytes(ctx context.Context, path string, query url.Values) ([]byte, error) {
+	u := c.BaseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	resp, err := c.Get(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		// ajax 接口出错时通常仍返回 {"error": true, "message": ...}
+		var env ajaxResponse
+		if json.Unmarshal(b, &env) == nil && env.Error {
+			return nil, &APIError{Path: path, StatusCode: resp.StatusCode, Message: env.Message}
+		}
+		// ranking.php 等旧接口为 {"error": "..."}
+		var legacy struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(b, &legacy) == nil && legacy.Error != "" {
+			return nil, &APIError{Path: path, StatusCode: resp.StatusCode, Message: legacy.Error}
+		}
+		return nil, &StatusError{URL: u, StatusCode: resp.StatusCode}
+	}
+	return b, nil
+}
+
+type ajaxResponse struct {
+	Error   bool            `json:"error"`
+	Message string          `json:"message"`
+	Body    json.RawMessage `json:"body"`
+}
+
+// getAjax 请求 /ajax 接口并把 body 字段解析到 v
+func (c *Client) getAjax(ctx context.Context, path string, query url.Values, v any) error {
+	b, err := c.getBytes(ctx, path, query)
+	if err != nil {
+		return err
+	}
+	var env ajaxResponse
+	if err = json.Unmarshal(b, &env); err != nil {
+		return &DecodeError{Path: path, Err: err}
+	}
+	if env.Error {
+		return &APIError{Path: path, StatusCode: http.StatusOK, Message: env.Message}
+	}
+	if err = json.Unmarshal(env.Body, v); err != nil {
+		return &DecodeError{Path: path, Err: err}
+	}
+	return nil
+}
+
+// APIError pixiv 接口返回了 error: true
+type APIError struct {
+	Path       string
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("pixiv api error: %s: %s", e.Path, e.Message)
+}
+
+// StatusError pixiv 返回了非 2xx 且无法解析的响应
+type StatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("pixiv: %s: unexpected status %d", e.URL, e.StatusCode)
+}
+
+// DecodeError 响应不是预期的 json 结构
+type DecodeError struct {
+	Path string
+	Err  error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("pixiv: decode %s: %v", e.Path, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,125 @@
+package pixiv
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestIDSetUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{`[]`, []string{}},
+		{` [ ] `, []string{}},
+		{`{}`, []string{}},
+		{`{"3": null, "10": null, "2": null}`, []string{"10", "3", "2"}},
+	}
+	for _, tt := range tests {
+		var s IDSet
+		if err := json.Unmarshal([]byte(tt.in), &s); err != nil {
+			t.Errorf("%s: %v", tt.in, err)
+			continue
+		}
+		if s == nil {
+			t.Errorf("%s: got nil set", tt.in)
+		}
+		if got := s.Sorted(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.in, got, tt.want)
+		}
+	}
+	var s IDSet
+	if err := json.Unmarshal([]byte(`"1"`), &s); err == nil {
+		t.Error("string: want error")
+	}
+}
+
+func TestProfileAllEmpty(t *testing.T) {
+	var all ProfileAll
+	if err := json.Unmarshal([]byte(`{"illusts": [], "manga": {"5": null}, "novels": []}`), &all); err != nil {
+		t.Fatal(err)
+	}
+	if got := all.Illusts.Union(all.Manga).Sorted(); !reflect.DeepEqual(got, []string{"5"}) {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		check  func(t *testing.T, err error)
+	}{
+		{"ok", 200, `{"error": false, "message": "", "body": {"id": "1", "title": "t"}}`, func(t *testing.T, err error) {
+			if err != nil {
+				t.Errorf("got %v", err)
+			}
+		}},
+		{"api error", 200, `{"error": true, "message": "deleted", "body": []}`, func(t *testing.T, err error) {
+			var e *APIError
+			if !errors.As(err, &e) || e.StatusCode != 200 || e.Message != "deleted" || e.Path != "/ajax/illust/1" {
+				t.Errorf("got %#v", err)
+			}
+		}},
+		{"api error with status", 404, `{"error": true, "message": "not found", "body": []}`, func(t *testing.T, err error) {
+			var e *APIError
+			if !errors.As(err, &e) || e.StatusCode != 404 || e.Message != "not found" {
+				t.Errorf("got %#v", err)
+			}
+		}},
+		{"legacy error", 400, `{"error": "invalid mode"}`, func(t *testing.T, err error) {
+			var e *APIError
+			if !errors.As(err, &e) || e.StatusCode != 400 || e.Message != "invalid mode" {
+				t.Errorf("got %#v", err)
+			}
+		}},
+		{"status error", 503, `<html>maintenance</html>`, func(t *testing.T, err error) {
+			var e *StatusError
+			if !errors.As(err, &e) || e.StatusCode != 503 {
+				t.Errorf("got %#v", err)
+			}
+		}},
+		{"invalid json", 200, `<html></html>`, func(t *testing.T, err error) {
+			var e *DecodeError
+			if !errors.As(err, &e) || e.Path != "/ajax/illust/1" {
+				t.Errorf("got %#v", err)
+			}
+			var syntax *json.SyntaxError
+			if !errors.As(err, &syntax) {
+				t.Errorf("DecodeError does not unwrap to *json.SyntaxError: %v", err)
+			}
+		}},
+		{"unexpected body", 200, `{"error": false, "body": {"id": 1}}`, func(t *testing.T, err error) {
+			var e *DecodeError
+			if !errors.As(err, &e) {
+				t.Errorf("got %#v", err)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/ajax/illust/1" {
+					t.Errorf("path %s", r.URL.Path)
+				}
+				if r.Header.Get("Referer") != DefaultReferer || r.Header.Get("Cookie") != "PHPSESSID=x" {
+					t.Errorf("headers %v", r.Header)
+				}
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+			c := NewClient(srv.Client())
+			c.BaseURL = srv.URL
+			c.Cookies = "PHPSESSID=x"
+			_, err := c.Illust(context.Background(), "1")
+			tt.check(t, err)
+		})
+	}
+}
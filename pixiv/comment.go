@@ -0,0 +1,76 @@
+package pixiv
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+)
+
+// Comment /ajax/illusts/comments/roots 和 replies 中的评论，
+// 贴图评论的 Comment 为空，StampID 不为空
+type Comment struct {
+	ID              string `json:"id"`
+	UserID          string `json:"userId"`
+	UserName        string `json:"userName"`
+	IsDeletedUser   bool   `json:"isDeletedUser"`
+	Img             string `json:"img"`
+	Comment         string `json:"comment"`
+	StampID         string `json:"stampId"`
+	CommentDate     string `json:"commentDate"`
+	CommentRootID   string `json:"commentRootId"`
+	CommentParentID string `json:"commentParentId"`
+	ReplyToUserID   string `json:"replyToUserId"`
+	ReplyToUserName string `json:"replyToUserName"`
+	HasReplies      bool   `json:"hasReplies"`
+}
+
+type CommentPage struct {
+	Comments []Comment `json:"comments"`
+	HasNext  bool      `json:"hasNext"`
+}
+
+// StampPath 贴图在 s.pximg.net 上的路径
+func StampPath(id string) string {
+	return "/common/images/stamp/generated-stamps/" + id + "_s.jpg"
+}
+
+// EmojiPath 表情在 s.pximg.net 上的路径，id 见 Emojis
+func EmojiPath(id int) string {
+	return "/common/images/emoji/" + strconv.Itoa(id) + ".png"
+}
+
+// Emojis 评论中 (name) 形式的表情对应的图片 id
+var Emojis = map[string]int{
+	"normal": 101, "surprise": 102, "serious": 103, "heaven": 104, "happy": 105, "excited": 106, "sing": 107, "cry": 108,
+	"normal2": 201, "shame2": 202, "love2": 203, "interesting2": 204, "blush2": 205, "fire2": 206, "angry2": 207, "shine2": 208, "panic2": 209,
+	"normal3": 301, "satisfaction3": 302, "surprise3": 303, "smile3": 304, "shock3": 305, "gaze3": 306, "wink3": 307, "happy3": 308, "excited3": 309, "love3": 310,
+	"normal4": 401, "surprise4": 402, "serious4": 403, "love4": 404, "shine4": 405, "sweat4": 406, "shame4": 407, "sleep4": 408,
+	"heart": 501, "teardrop": 502, "star": 503,
+}
+
+// CommentRoots 作品的顶层评论，按时间从新到旧，offset 从 0 开始
+func (c *Client) CommentRoots(ctx context.Context, pid string, offset, limit int) (*CommentPage, error) {
+	var page CommentPage
+	query := url.Values{
+		"illust_id": {pid},
+		"offset":    {strconv.Itoa(offset)},
+		"limit":     {strconv.Itoa(limit)},
+	}
+	if err := c.getAjax(ctx, "/ajax/illusts/comments/roots", query, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// CommentReplies 评论的回复，page 从 1 开始
+func (c *Client) CommentReplies(ctx context.Context, commentID string, page int) (*CommentPage, error) {
+	var ret CommentPage
+	query := url.Values{
+		"comment_id": {commentID},
+		"page":       {strconv.Itoa(page)},
+	}
+	if err := c.getAjax(ctx, "/ajax/illusts/comments/replies", query, &ret); err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
@@ -0,0 +1,152 @@
+package pixiv
+
+import (
+	"context"
+	"net/url"
+)
+
+// 作品类型
+const (
+	IllustTypeIllust = 0
+	IllustTypeManga  = 1
+	IllustTypeUgoira = 2
+)
+
+// Illust /ajax/illust/<pid> 返回的作品详情
+type Illust struct {
+	ID            string     `json:"id"`
+	Title         string     `json:"title"`
+	Description   string     `json:"description"`
+	IllustType    int        `json:"illustType"`
+	CreateDate    string     `json:"createDate"`
+	UploadDate    string     `json:"uploadDate"`
+	UserID        string     `json:"userId"`
+	UserName      string     `json:"userName"`
+	BookmarkCount int64      `json:"bookmarkCount"`
+	LikeCount     int64      `json:"likeCount"`
+	ViewCount     int64      `json:"viewCount"`
+	AiType        int        `json:"aiType"`
+	XRestrict     int        `json:"xRestrict"`
+	Tags          IllustTags `json:"tags"`
+	Urls          ImageURLs  `json:"urls"`
+	PageCount     int        `json:"pageCount"`
+	Width         int        `json:"width"`
+	Height        int        `json:"height"`
+	// SeriesNavData 作品所属的漫画系列，不属于系列时为 nil
+	SeriesNavData *IllustSeriesNav `json:"seriesNavData"`
+}
+
+// IllustSeriesNav 作品在漫画系列中的位置，Prev、Next 在首尾时为 nil
+type IllustSeriesNav struct {
+	SeriesType string               `json:"seriesType"`
+	SeriesID   string               `json:"seriesId"`
+	Title      string               `json:"title"`
+	Order      int                  `json:"order"`
+	Prev       *IllustSeriesNavLink `json:"prev"`
+	Next       *IllustSeriesNavLink `json:"next"`
+}
+
+type IllustSeriesNavLink struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	Order int    `json:"order"`
+}
+
+type IllustTags struct {
+	Tags []IllustTag `json:"tags"`
+}
+
+type IllustTag struct {
+	Tag       string `json:"tag"`
+	Locked    bool   `json:"locked"`
+	Deletable bool   `json:"deletable"`
+	UserID    string `json:"userId"`
+	UserName  string `json:"userName"`
+}
+
+// ImageURLs 作品第一页各尺寸的图片地址，未登录时 R-18 作品的地址为空
+type ImageURLs struct {
+	Mini     string `json:"mini"`
+	Thumb    string `json:"thumb"`
+	Small    string `json:"small"`
+	Regular  string `json:"regular"`
+	Original string `json:"original"`
+}
+
+// Map 按 original / regular / small / thumb / mini 索引图片地址
+func (u ImageURLs) Map() map[string]string {
+	return map[string]string{
+		"original": u.Original,
+		"regular":  u.Regular,
+		"small":    u.Small,
+		"thumb":    u.Thumb,
+		"mini":     u.Mini,
+	}
+}
+
+// Page /ajax/illust/<pid>/pages 中的单页
+type Page struct {
+	Urls   PageURLs `json:"urls"`
+	Width  int      `json:"width"`
+	Height int      `json:"height"`
+}
+
+type PageURLs struct {
+	ThumbMini string `json:"thumb_mini"`
+	Small     string `json:"small"`
+	Regular   string `json:"regular"`
+	Original  string `json:"original"`
+}
+
+// UgoiraMeta /ajax/illust/<pid>/ugoira_meta 返回的动图信息
+type UgoiraMeta struct {
+	Src         string        `json:"src"`
+	OriginalSrc string        `json:"originalSrc"`
+	MimeType    string        `json:"mime_type"`
+	Frames      []UgoiraFrame `json:"frames"`
+}
+
+type UgoiraFrame struct {
+	File  string `json:"file"`
+	Delay int    `json:"delay"`
+}
+
+func (c *Client) Illust(ctx context.Context, pid string) (*Illust, error) {
+	var illust Illust
+	if err := c.getAjax(ctx, "/ajax/illust/"+url.PathEscape(pid), nil, &illust); err != nil {
+		return nil, err
+	}
+	return &illust, nil
+}
+
+func (c *Client) Pages(ctx context.Context, pid string) ([]Page, error) {
+	var pages []Page
+	if err := c.getAjax(ctx, "/ajax/illust/"+url.PathEscape(pid)+"/pages", nil, &pages); err != nil {
+		return nil, err
+	}
+	return pages, nil
+}
+
+func (c *Client) UgoiraMeta(ctx context.Context, pid string) (*UgoiraMeta, error) {
+	var meta UgoiraMeta
+	if err := c.getAjax(ctx, "/ajax/illust/"+url.PathEscape(pid)+"/ugoira_meta", nil, &meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// FrequentTag /ajax/tags/frequent/illust 返回的标签，TagTranslation 为当前语言的翻译，没有时为空
+type FrequentTag struct {
+	Tag            string `json:"tag"`
+	TagTranslation string `json:"tag_translation"`
+}
+
+// FrequentTags 一组作品中常用的标签
+func (c *Client) FrequentTags(ctx context.Context, pids []string) ([]FrequentTag, error) {
+	var tags []FrequentTag
+	query := url.Values{"ids[]": pids}
+	if err := c.getAjax(ctx, "/ajax/tags/frequent/illust", query, &tags); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
@@ -0,0 +1,158 @@
+package pixiv
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/url"
+	"strconv"
+)
+
+// Novel /ajax/novel/<id> 返回的小说详情，Content 为带 [newpage]、[pixivimage:] 等标记的正文
+type Novel struct {
+	ID             string          `json:"id"`
+	Title          string          `json:"title"`
+	Description    string          `json:"description"`
+	Content        string          `json:"content"`
+	CoverURL       string          `json:"coverUrl"`
+	CreateDate     string          `json:"createDate"`
+	UploadDate     string          `json:"uploadDate"`
+	UserID         string          `json:"userId"`
+	UserName       string          `json:"userName"`
+	BookmarkCount  int64           `json:"bookmarkCount"`
+	ViewCount      int64           `json:"viewCount"`
+	XRestrict      int             `json:"xRestrict"`
+	AiType         int             `json:"aiType"`
+	IsOriginal     bool            `json:"isOriginal"`
+	Language       string          `json:"language"`
+	CharacterCount int             `json:"characterCount"`
+	WordCount      int             `json:"wordCount"`
+	Tags           IllustTags      `json:"tags"`
+	SeriesNavData  *NovelSeriesNav `json:"seriesNavData"`
+	TextEmbedded   NovelImages     `json:"textEmbeddedImages"`
+}
+
+// NovelSeriesNav 小说在系列中的位置
+type NovelSeriesNav struct {
+	SeriesID int64               `json:"seriesId"`
+	Title    string              `json:"title"`
+	Order    int                 `json:"order"`
+	Prev     *NovelSeriesNavLink `json:"prev"`
+	Next     *NovelSeriesNavLink `json:"next"`
+}
+
+type NovelSeriesNavLink struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	Order int    `json:"order"`
+}
+
+// NovelImages 正文中 [uploadedimage:<id>] 对应的图片，没有时 pixiv 返回 null 或 []
+type NovelImages map[string]NovelEI
+
+func (m *NovelImages) UnmarshalJSON(b []byte) error {
+	*m = NovelImages{}
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte("[")) || bytes.Equal(bytes.TrimSpace(b), []byte("null")) {
+		return nil
+	}
+	return json.Unmarshal(b, (*map[string]NovelEI)(m))
+}
+
+type NovelEI struct {
+	NovelImageID string            `json:"novelImageId"`
+	Urls         map[string]string `json:"urls"`
+}
+
+// NovelSeries /ajax/novel/series/<id> 返回的系列信息
+type NovelSeries struct {
+	ID                    string   `json:"id"`
+	UserID                string   `json:"userId"`
+	UserName              string   `json:"userName"`
+	Title                 string   `json:"title"`
+	Caption               string   `json:"caption"`
+	Language              string   `json:"language"`
+	Tags                  []string `json:"tags"`
+	PublishedContentCount int      `json:"publishedContentCount"`
+	FirstNovelID          string   `json:"firstNovelId"`
+	LatestNovelID         string   `json:"latestNovelId"`
+	XRestrict             int      `json:"xRestrict"`
+	AiType                int      `json:"aiType"`
+	IsOriginal            bool     `json:"isOriginal"`
+	CreateDate            string   `json:"createDate"`
+	UpdateDate            string   `json:"updateDate"`
+	Cover                 struct {
+		Urls map[string]string `json:"urls"`
+	} `json:"cover"`
+}
+
+// NovelBrief 系列目录、用户作品列表中的小说简要信息
+type NovelBrief struct {
+	ID             string   `json:"id"`
+	Title          string   `json:"title"`
+	Description    string   `json:"description"`
+	URL            string   `json:"url"`
+	Tags           []string `json:"tags"`
+	UserID         string   `json:"userId"`
+	UserName       string   `json:"userName"`
+	XRestrict      int      `json:"xRestrict"`
+	AiType         int      `json:"aiType"`
+	IsOriginal     bool     `json:"isOriginal"`
+	TextCount      int      `json:"textCount"`
+	CharacterCount int      `json:"characterCount"`
+	WordCount      int      `json:"wordCount"`
+	BookmarkCount  int64    `json:"bookmarkCount"`
+	CreateDate     string   `json:"createDate"`
+	SeriesID       string   `json:"seriesId"`
+	SeriesTitle    string   `json:"seriesTitle"`
+	// UploadTimestamp 只在系列目录中返回
+	UploadTimestamp int64 `json:"uploadTimestamp"`
+}
+
+func (c *Client) Novel(ctx context.Context, id string) (*Novel, error) {
+	var novel Novel
+	if err := c.getAjax(ctx, "/ajax/novel/"+url.PathEscape(id), nil, &novel); err != nil {
+		return nil, err
+	}
+	return &novel, nil
+}
+
+func (c *Client) NovelSeries(ctx context.Context, id string) (*NovelSeries, error) {
+	var series NovelSeries
+	if err := c.getAjax(ctx, "/ajax/novel/series/"+url.PathEscape(id), nil, &series); err != nil {
+		return nil, err
+	}
+	return &series, nil
+}
+
+// NovelSeriesContent 按系列中的顺序返回从 lastOrder 之后的 limit 篇小说（limit 最大 30）
+func (c *Client) NovelSeriesContent(ctx context.Context, id string, lastOrder, limit int) ([]NovelBrief, error) {
+	var body struct {
+		Page struct {
+			SeriesContents []NovelBrief `json:"seriesContents"`
+		} `json:"page"`
+	}
+	query := url.Values{
+		"limit":      {strconv.Itoa(limit)},
+		"last_order": {strconv.Itoa(lastOrder)},
+		"order_by":   {"asc"},
+	}
+	if err := c.getAjax(ctx, "/ajax/novel/series_content/"+url.PathEscape(id), query, &body); err != nil {
+		return nil, err
+	}
+	return body.Page.SeriesContents, nil
+}
+
+// UserNovels 批量获取用户的小说简要信息，ids 为空时返回空
+func (c *Client) UserNovels(ctx context.Context, uid string, ids []string) (map[string]NovelBrief, error) {
+	if len(ids) == 0 {
+		return map[string]NovelBrief{}, nil
+	}
+	var body struct {
+		Works map[string]NovelBrief `json:"works"`
+	}
+	query := url.Values{"ids[]": ids}
+	if err := c.getAjax(ctx, "/ajax/user/"+url.PathEscape(uid)+"/profile/novels", query, &body); err != nil {
+		return nil, err
+	}
+	return body.Works, nil
+}
@@ -0,0 +1,107 @@
+package pixiv
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strconv"
+)
+
+// RankingOptions ranking.php 的查询参数，为空的字段不发送
+type RankingOptions struct {
+	// Mode daily, weekly, monthly, rookie, original, male, female, daily_r18 等
+	Mode string
+	// Content illust, manga, ugoira
+	Content string
+	// Date yyyymmdd
+	Date string
+	// Page 从 1 开始，每页 50 个作品
+	Page int
+}
+
+// Ranking ranking.php?format=json 的返回，该接口没有 ajax 的 error/body 包装
+type Ranking struct {
+	Contents  []RankingItem `json:"contents"`
+	Mode      string        `json:"mode"`
+	Content   string        `json:"content"`
+	Page      int           `json:"page"`
+	Prev      any           `json:"prev"`
+	Next      any           `json:"next"`
+	Date      string        `json:"date"`
+	PrevDate  any           `json:"prev_date"`
+	NextDate  any           `json:"next_date"`
+	RankTotal int           `json:"rank_total"`
+}
+
+type RankingItem struct {
+	Title             string            `json:"title"`
+	Date              string            `json:"date"`
+	Tags              []string          `json:"tags"`
+	URL               string            `json:"url"`
+	IllustType        string            `json:"illust_type"`
+	IllustBookStyle   string            `json:"illust_book_style"`
+	IllustPageCount   string            `json:"illust_page_count"`
+	UserName          string            `json:"user_name"`
+	ProfileImg        string            `json:"profile_img"`
+	IllustContentType IllustContentType `json:"illust_content_type"`
+	IllustSeries      any               `json:"illust_series"`
+	IllustID          int64             `json:"illust_id"`
+	Width             int               `json:"width"`
+	Height            int               `json:"height"`
+	UserID            int64             `json:"user_id"`
+	Rank              int               `json:"rank"`
+	YesRank           int               `json:"yes_rank"`
+	RatingCount       int               `json:"rating_count"`
+	ViewCount         int               `json:"view_count"`
+	IllustUploadTime  int64             `json:"illust_upload_timestamp"`
+	Attr              string            `json:"attr"`
+	IsBookmarked      bool              `json:"is_bookmarked"`
+	Bookmarkable      bool              `json:"bookmarkable"`
+}
+
+type IllustContentType struct {
+	Sexual     int  `json:"sexual"`
+	Lo         bool `json:"lo"`
+	Grotesque  bool `json:"grotesque"`
+	Violent    bool `json:"violent"`
+	HomoSexual bool `json:"homosexual"`
+	Drug       bool `json:"drug"`
+	Thoughts   bool `json:"thoughts"`
+	Antisocial bool `json:"antisocial"`
+	Religion   bool `json:"religion"`
+	Original   bool `json:"original"`
+	Furry      bool `json:"furry"`
+	Bl         bool `json:"bl"`
+	Yuri       bool `json:"yuri"`
+}
+
+func (c *Client) Ranking(ctx context.Context, opt RankingOptions) (*Ranking, error) {
+	query := url.Values{"format": {"json"}}
+	if opt.Mode != "" {
+		query.Set("mode", opt.Mode)
+	}
+	if opt.Content != "" {
+		query.Set("content", opt.Content)
+	}
+	if opt.Date != "" {
+		query.Set("date", opt.Date)
+	}
+	if opt.Page > 0 {
+		query.Set("p", strconv.Itoa(opt.Page))
+	}
+	b, err := c.getBytes(ctx, "/ranking.php", query)
+	if err != nil {
+		return nil, err
+	}
+	var errResp struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(b, &errResp) == nil && errResp.Error != "" {
+		return nil, &APIError{Path: "/ranking.php", StatusCode: 200, Message: errResp.Error}
+	}
+	var ranking Ranking
+	if err = json.Unmarshal(b, &ranking); err != nil {
+		return nil, &DecodeError{Path: "/ranking.php", Err: err}
+	}
+	return &ranking, nil
+}
@@ -0,0 +1,39 @@
+package pixiv
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+)
+
+// RecommendInit /ajax/illust/<pid>/recommend/init 的返回，
+// Illusts 为前 limit 个推荐作品，其余推荐作品只有 id，需要再用 RecommendIllusts 获取
+type RecommendInit struct {
+	Illusts []IllustBrief `json:"illusts"`
+	NextIDs []string      `json:"nextIds"`
+}
+
+// RecommendInit 获取与作品相关的推荐作品
+func (c *Client) RecommendInit(ctx context.Context, pid string, limit int) (*RecommendInit, error) {
+	var rec RecommendInit
+	query := url.Values{"limit": {strconv.Itoa(limit)}}
+	if err := c.getAjax(ctx, "/ajax/illust/"+url.PathEscape(pid)+"/recommend/init", query, &rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// RecommendIllusts 按 id 批量获取推荐作品的简要信息，返回的顺序不一定与 ids 相同
+func (c *Client) RecommendIllusts(ctx context.Context, ids []string) ([]IllustBrief, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	var body struct {
+		Illusts []IllustBrief `json:"illusts"`
+	}
+	query := url.Values{"illust_ids[]": ids}
+	if err := c.getAjax(ctx, "/ajax/illust/recommend/illusts", query, &body); err != nil {
+		return nil, err
+	}
+	return body.Illusts, nil
+}
@@ -0,0 +1,49 @@
+package pixiv
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+)
+
+// SearchResult /ajax/search/artworks/<word> 的返回，每页 60 个作品
+type SearchResult struct {
+	IllustManga struct {
+		Total    int64         `json:"total"`
+		LastPage int           `json:"lastPage"`
+		Data     []IllustBrief `json:"data"`
+	} `json:"illustManga"`
+}
+
+// IllustBrief 搜索、排行、用户作品列表中的作品简要信息
+type IllustBrief struct {
+	ID              string   `json:"id"`
+	Title           string   `json:"title"`
+	IllustType      int      `json:"illustType"`
+	XRestrict       int      `json:"xRestrict"`
+	AiType          int      `json:"aiType"`
+	URL             string   `json:"url"`
+	Tags            []string `json:"tags"`
+	UserID          string   `json:"userId"`
+	UserName        string   `json:"userName"`
+	Width           int      `json:"width"`
+	Height          int      `json:"height"`
+	PageCount       int      `json:"pageCount"`
+	CreateDate      string   `json:"createDate"`
+	ProfileImageURL string   `json:"profileImageUrl"`
+	// IsAdContainer 推荐列表中的广告位，没有作品信息
+	IsAdContainer bool `json:"isAdContainer"`
+}
+
+// Search 按关键词搜索插画和漫画，page 从 1 开始，0 表示第一页
+func (c *Client) Search(ctx context.Context, word string, page int) (*SearchResult, error) {
+	var query url.Values
+	if page > 0 {
+		query = url.Values{"p": {strconv.Itoa(page)}}
+	}
+	var result SearchResult
+	if err := c.getAjax(ctx, "/ajax/search/artworks/"+url.PathEscape(word), query, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
@@ -0,0 +1,61 @@
+package pixiv
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+)
+
+// SeriesPageSize /ajax/series/<id> 每页返回的作品数
+const SeriesPageSize = 12
+
+// SeriesPage /ajax/series/<id>?p=<page> 返回的漫画系列信息和一页作品
+type SeriesPage struct {
+	IllustSeries []IllustSeries `json:"illustSeries"`
+	Page         struct {
+		Series []SeriesWork `json:"series"`
+		Total  int          `json:"total"`
+	} `json:"page"`
+	Thumbnails struct {
+		Illust []IllustBrief `json:"illust"`
+	} `json:"thumbnails"`
+}
+
+type IllustSeries struct {
+	ID             string `json:"id"`
+	UserID         string `json:"userId"`
+	Title          string `json:"title"`
+	Caption        string `json:"caption"`
+	Total          int    `json:"total"`
+	URL            string `json:"url"`
+	FirstIllustID  string `json:"firstIllustId"`
+	LatestIllustID string `json:"latestIllustId"`
+	CreateDate     string `json:"createDate"`
+	UpdateDate     string `json:"updateDate"`
+}
+
+// SeriesWork 系列中的一个作品，Order 从 1 开始
+type SeriesWork struct {
+	WorkID string `json:"workId"`
+	Order  int    `json:"order"`
+}
+
+// Info 返回 id 对应的系列信息，illustSeries 中还会包含同一作者的其他系列
+func (s *SeriesPage) Info(id string) *IllustSeries {
+	for i := range s.IllustSeries {
+		if s.IllustSeries[i].ID == id {
+			return &s.IllustSeries[i]
+		}
+	}
+	return nil
+}
+
+// Series 获取漫画系列，page 从 1 开始
+func (c *Client) Series(ctx context.Context, id string, page int) (*SeriesPage, error) {
+	var series SeriesPage
+	query := url.Values{"p": {strconv.Itoa(page)}}
+	if err := c.getAjax(ctx, "/ajax/series/"+url.PathEscape(id), query, &series); err != nil {
+		return nil, err
+	}
+	return &series, nil
+}
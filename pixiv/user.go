@@ -0,0 +1,121 @@
+package pixiv
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/url"
+	"sort"
+	"strconv"
+)
+
+// User /ajax/user/<uid> 返回的用户信息
+type User struct {
+	UserID      string          `json:"userId"`
+	Name        string          `json:"name"`
+	Image       string          `json:"image"`
+	ImageBig    string          `json:"imageBig"`
+	Premium     bool            `json:"premium"`
+	IsFollowed  bool            `json:"isFollowed"`
+	IsMypixiv   bool            `json:"isMypixiv"`
+	IsBlocking  bool            `json:"isBlocking"`
+	Background  *UserBackground `json:"background"`
+	Comment     string          `json:"comment"`
+	Partial     int             `json:"partial"`
+	SketchLives []any           `json:"sketchLives"`
+	// Commission string 或 null
+	Commission any `json:"commission"`
+}
+
+type UserBackground struct {
+	Repeat    string `json:"repeat"`
+	Color     string `json:"color"`
+	URL       string `json:"url"`
+	IsPrivate bool   `json:"isPrivate"`
+}
+
+// ProfileAll /ajax/user/<uid>/profile/all 返回的全部作品 id
+type ProfileAll struct {
+	Illusts IDSet `json:"illusts"`
+	Manga   IDSet `json:"manga"`
+	Novels  IDSet `json:"novels"`
+}
+
+// IDSet 作品 id 的集合。pixiv 有作品时返回 {"id": null, ...}，没有作品时返回 []
+type IDSet map[string]struct{}
+
+func (s *IDSet) UnmarshalJSON(b []byte) error {
+	*s = IDSet{}
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte("[")) {
+		return nil
+	}
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	for k := range m {
+		(*s)[k] = struct{}{}
+	}
+	return nil
+}
+
+// Sorted 按 id 数值从大到小（即从新到旧）返回
+func (s IDSet) Sorted() []string {
+	ids := make([]string, 0, len(s))
+	for k := range s {
+		ids = append(ids, k)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, _ := strconv.ParseInt(ids[i], 10, 64)
+		b, _ := strconv.ParseInt(ids[j], 10, 64)
+		return a > b
+	})
+	return ids
+}
+
+// Union 返回 s 与 other 的并集
+func (s IDSet) Union(other IDSet) IDSet {
+	ret := make(IDSet, len(s)+len(other))
+	for k := range s {
+		ret[k] = struct{}{}
+	}
+	for k := range other {
+		ret[k] = struct{}{}
+	}
+	return ret
+}
+
+func (c *Client) User(ctx context.Context, uid string) (*User, error) {
+	var user User
+	if err := c.getAjax(ctx, "/ajax/user/"+url.PathEscape(uid), nil, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (c *Client) UserProfileAll(ctx context.Context, uid string) (*ProfileAll, error) {
+	var all ProfileAll
+	if err := c.getAjax(ctx, "/ajax/user/"+url.PathEscape(uid)+"/profile/all", nil, &all); err != nil {
+		return nil, err
+	}
+	return &all, nil
+}
+
+// UserIllusts 批量获取用户插画和漫画的简要信息，ids 为空时返回空，pixiv 一次最多返回 48 个
+func (c *Client) UserIllusts(ctx context.Context, uid string, ids []string) (map[string]IllustBrief, error) {
+	if len(ids) == 0 {
+		return map[string]IllustBrief{}, nil
+	}
+	var body struct {
+		Works map[string]IllustBrief `json:"works"`
+	}
+	query := url.Values{
+		"ids[]":         ids,
+		"work_category": {"illustManga"},
+		"is_first_page": {"0"},
+	}
+	if err := c.getAjax(ctx, "/ajax/user/"+url.PathEscape(uid)+"/profile/illusts", query, &body); err != nil {
+		return nil, err
+	}
+	return body.Works, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// defaultPximgSizes 直链中 /c/<W>x<H> 缩略图允许的尺寸
+var defaultPximgSizes = []string{
+	"48x48", "50x50", "128x128", "150x150", "170x170", "240x240", "250x250",
+	"360x360", "400x400", "540x540", "600x600", "600x1200", "1200x1200",
+	// 小说封面
+	"240x480", "480x960",
+	// 用户主页背景
+	"1920x960",
+}
+
+const pximgDate = `\d{4}/\d{2}/\d{2}/\d{2}/\d{2}/\d{2}`
+
+// pximgKinds 各类直链的完整路径语法，子匹配依次为 日期、文件名、id
+var pximgKinds = map[string]*regexp.Regexp{
+	// 动图的各帧为 <id>_ugoira<帧号>
+	"img-original":   regexp.MustCompile(`^img/(` + pximgDate + `)/((\d+)_(?:p|ugoira)\d+\.(?:jpg|png|gif))$`),
+	"img-master":     regexp.MustCompile(`^img/(` + pximgDate + `)/((\d+)_(?:p|ugoira)\d+_(?:master|square)1200\.(?:jpg|png|gif))$`),
+	"custom-thumb":   regexp.MustCompile(`^img/(` + pximgDate + `)/((\d+)_p\d+_custom1200\.(?:jpg|png|gif))$`),
+	"img-zip-ugoira": regexp.MustCompile(`^img/(` + pximgDate + `)/((\d+)_ugoira\d{2,4}x\d{2,4}\.zip)$`),
+	"user-profile":   regexp.MustCompile(`^img/(` + pximgDate + `)/((\d+)_[0-9a-f]{32}(?:_\d{2,3}|_s)?\.(?:jpg|png|gif))$`),
+	"background":     regexp.MustCompile(`^img/(` + pximgDate + `)/((\d+)_[0-9a-f]{32}\.(?:jpg|png|gif))$`),
+	// 小说封面（ci<小说 id>）和正文插图（tei<图片 id>）
+	"novel-cover-original": regexp.MustCompile(`^img/(` + pximgDate + `)/((?:ci|tei)(\d+)_[0-9a-zA-Z]+\.(?:jpg|png|gif))$`),
+	"novel-cover-master":   regexp.MustCompile(`^img/(` + pximgDate + `)/((?:ci|tei)(\d+)_[0-9a-zA-Z]+_master1200\.(?:jpg|png|gif))$`),
+}
+
+// pximgCropKinds 可以带 /c/ 前缀的类型，以及不带前缀时可直接访问的类型
+var (
+	pximgCropKinds   = []string{"img-master", "custom-thumb", "user-profile", "background", "novel-cover-master"}
+	pximgDirectKinds = []string{"img-original", "img-master", "img-zip-ugoira", "user-profile", "background", "novel-cover-original", "novel-cover-master"}
+)
+
+// pximgCropOpt /c/<W>x<H> 之后允许的选项：画质、a2 裁剪、g5 等模糊、webp
+var pximgCropOpt = regexp.MustCompile(`^(?:\d{1,3}|a2|g\d|webp)$`)
+
+var errPximgPath = errors.New("invalid image path")
+
+// pximgPath 校验通过的 i.pximg.net 路径
+type pximgPath struct {
+	// Crop /c/ 后的尺寸段，如 250x250_80_a2，没有时为空
+	Crop string
+	Kind string
+	Date string
+	File string
+	// ID 作品 id，Kind 为 user-profile、background 时为用户 id，小说封面为小说 id 或图片 id
+	ID string
+}
+
+// parsePximgPath 按 pximg 的路径语法严格解析，不符合的路径一律拒绝，
+// 防止通过编码的 ..、多余的 / 或任意缩略图参数访问非预期的地址
+func parsePximgPath(p string, sizes []string) (*pximgPath, error) {
+	rest, ok := strings.CutPrefix(p, "/")
+	if !ok {
+		return nil, errPximgPath
+	}
+	ret := &pximgPath{}
+	if strings.HasPrefix(rest, "c/") {
+		seg, after, ok := strings.Cut(rest[2:], "/")
+		if !ok {
+			return nil, errPximgPath
+		}
+		opts := strings.Split(seg, "_")
+		if !in(sizes, opts[0]) {
+			return nil, errors.New("thumbnail size not allowed")
+		}
+		for _, o := range opts[1:] {
+			if !pximgCropOpt.MatchString(o) {
+				return nil, errPximgPath
+			}
+		}
+		ret.Crop = seg
+		rest = after
+	}
+	kind, rest, ok := strings.Cut(rest, "/")
+	if !ok {
+		return nil, errPximgPath
+	}
+	if (ret.Crop != "" && !in(pximgCropKinds, kind)) || (ret.Crop == "" && !in(pximgDirectKinds, kind)) {
+		return nil, errPximgPath
+	}
+	m := pximgKinds[kind].FindStringSubmatch(rest)
+	if m == nil {
+		return nil, errPximgPath
+	}
+	ret.Kind, ret.Date, ret.File, ret.ID = kind, m[1], m[2], m[3]
+	return ret, nil
+}
+
+// String 返回规范化后的路径，转发时使用它而不是客户端传入的原始路径
+func (p *pximgPath) String() string {
+	s := "/" + p.Kind + "/img/" + p.Date + "/" + p.File
+	if p.Crop != "" {
+		s = "/c/" + p.Crop + s
+	}
+	return s
+}
+
+// illustID 作品 id，头像、背景、小说图片等不属于插画的路径返回空
+func (p *pximgPath) illustID() string {
+	if p.Kind == "user-profile" || p.Kind == "background" || strings.HasPrefix(p.Kind, "novel-") {
+		return ""
+	}
+	return p.ID
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParsePximgPath(t *testing.T) {
+	tests := []struct {
+		path string
+		// want 解析后的 Kind 和 ID，为空表示应拒绝
+		kind, id string
+	}{
+		{"/img-original/img/2022/05/01/00/00/00/98505703_p0.png", "img-original", "98505703"},
+		{"/img-master/img/2022/05/01/00/00/00/98505703_p1_master1200.jpg", "img-master", "98505703"},
+		{"/c/250x250_80_a2/img-master/img/2022/05/01/00/00/00/98505703_p0_square1200.jpg", "img-master", "98505703"},
+		{"/c/250x250_80_a2/custom-thumb/img/2022/05/01/00/00/00/98505703_p0_custom1200.jpg", "custom-thumb", "98505703"},
+		{"/img-zip-ugoira/img/2022/05/01/00/00/00/44298467_ugoira600x600.zip", "img-zip-ugoira", "44298467"},
+		{"/user-profile/img/2022/05/01/00/00/00/11_0123456789abcdef0123456789abcdef_170.jpg", "user-profile", "11"},
+		{"/c/480x960/novel-cover-master/img/2022/05/01/00/00/00/ci123_abcDEF_master1200.jpg", "novel-cover-master", "123"},
+
+		// 动图各帧
+		{"/img-original/img/2022/05/01/00/00/00/44298467_ugoira0.jpg", "img-original", "44298467"},
+		{"/img-original/img/2022/05/01/00/00/00/44298467_ugoira12.png", "img-original", "44298467"},
+		{"/img-master/img/2022/05/01/00/00/00/44298467_ugoira0_master1200.jpg", "img-master", "44298467"},
+		{"/c/250x250_80_a2/img-master/img/2022/05/01/00/00/00/44298467_ugoira0_square1200.jpg", "img-master", "44298467"},
+		{"/img-original/img/2022/05/01/00/00/00/44298467_ugoira.jpg", "", ""},
+		{"/img-original/img/2022/05/01/00/00/00/44298467_ugoira0_master1200.jpg", "", ""},
+
+		// .. 和编码后的 ..（URL.Path 已解码）
+		{"/img-original/img/2022/05/01/00/00/00/../98505703_p0.png", "", ""},
+		{"/img-original/img/2022/05/01/00/00/00/..%2f98505703_p0.png", "", ""},
+		{"/c/250x250/../img-original/img/2022/05/01/00/00/00/98505703_p0.png", "", ""},
+		{"/img-original/../img-original/img/2022/05/01/00/00/00/98505703_p0.png", "", ""},
+		// 多余的 /
+		{"//img-original/img/2022/05/01/00/00/00/98505703_p0.png", "", ""},
+		{"/img-original//img/2022/05/01/00/00/00/98505703_p0.png", "", ""},
+		{"/c//img-master/img/2022/05/01/00/00/00/98505703_p0_master1200.jpg", "", ""},
+		{"/img-original/img/2022/05/01/00/00/00//98505703_p0.png", "", ""},
+		// 查询参数和片段
+		{"/img-original/img/2022/05/01/00/00/00/98505703_p0.png?x=1", "", ""},
+		{"/img-original/img/2022/05/01/00/00/00/98505703_p0.png#x", "", ""},
+		// 不允许的缩略图尺寸和选项
+		{"/c/9999x9999/img-master/img/2022/05/01/00/00/00/98505703_p0_master1200.jpg", "", ""},
+		{"/c/250x250_q/img-master/img/2022/05/01/00/00/00/98505703_p0_master1200.jpg", "", ""},
+		{"/c/250x250/img-original/img/2022/05/01/00/00/00/98505703_p0.png", "", ""},
+		{"/c/250x250/img-zip-ugoira/img/2022/05/01/00/00/00/44298467_ugoira600x600.zip", "", ""},
+		// 不带 /c/ 时不能直接访问的类型
+		{"/custom-thumb/img/2022/05/01/00/00/00/98505703_p0_custom1200.jpg", "", ""},
+		{"/unknown/img/2022/05/01/00/00/00/98505703_p0.png", "", ""},
+		{"img-original/img/2022/05/01/00/00/00/98505703_p0.png", "", ""},
+		{"/img-original/img/2022/5/1/00/00/00/98505703_p0.png", "", ""},
+	}
+	for _, tt := range tests {
+		p, err := parsePximgPath(tt.path, defaultPximgSizes)
+		if tt.kind == "" {
+			if err == nil {
+				t.Errorf("%s: accepted as %s, want error", tt.path, p)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.path, err)
+			continue
+		}
+		if p.Kind != tt.kind || p.ID != tt.id {
+			t.Errorf("%s: got kind %q id %q, want %q %q", tt.path, p.Kind, p.ID, tt.kind, tt.id)
+		}
+		if p.String() != tt.path {
+			t.Errorf("%s: String() = %s", tt.path, p)
+		}
+	}
+}
+
+func TestPximgPathIllustID(t *testing.T) {
+	tests := map[string]string{
+		"/img-original/img/2022/05/01/00/00/00/98505703_p0.png":                                  "98505703",
+		"/img-master/img/2022/05/01/00/00/00/44298467_ugoira0_master1200.jpg":                    "44298467",
+		"/user-profile/img/2022/05/01/00/00/00/11_0123456789abcdef0123456789abcdef.jpg":          "",
+		"/novel-cover-original/img/2022/05/01/00/00/00/ci123_abcDEF.jpg":                         "",
+		"/c/1920x960/background/img/2022/05/01/00/00/00/11_0123456789abcdef0123456789abcdef.jpg": "",
+	}
+	for path, want := range tests {
+		p, err := parsePximgPath(path, defaultPximgSizes)
+		if err != nil {
+			t.Errorf("%s: %v", path, err)
+			continue
+		}
+		if got := p.illustID(); got != want {
+			t.Errorf("%s: illustID() = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func FuzzParsePximgPath(f *testing.F) {
+	for _, s := range []string{
+		"/img-original/img/2022/05/01/00/00/00/98505703_p0.png",
+		"/img-original/img/2022/05/01/00/00/00/44298467_ugoira0.jpg",
+		"/c/250x250_80_a2/img-master/img/2022/05/01/00/00/00/44298467_ugoira0_square1200.jpg",
+		"/img-zip-ugoira/img/2022/05/01/00/00/00/44298467_ugoira600x600.zip",
+		"/user-profile/img/2022/05/01/00/00/00/11_0123456789abcdef0123456789abcdef_170.jpg",
+		"/c/480x960/novel-cover-master/img/2022/05/01/00/00/00/ci123_abcDEF_master1200.jpg",
+		"/img-original/img/2022/05/01/00/00/00/../98505703_p0.png",
+		"//img-original/img/2022/05/01/00/00/00/98505703_p0.png",
+	} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, path string) {
+		p, err := parsePximgPath(path, defaultPximgSizes)
+		if err != nil {
+			return
+		}
+		s := p.String()
+		for _, bad := range []string{"..", "//", "?", "#"} {
+			if strings.Contains(path, bad) || strings.Contains(s, bad) {
+				t.Fatalf("accepted %q (%q) containing %q", path, s, bad)
+			}
+		}
+		q, err := parsePximgPath(s, defaultPximgSizes)
+		if err != nil {
+			t.Fatalf("%q: String() %q rejected: %v", path, s, err)
+		}
+		if *q != *p {
+			t.Fatalf("%q: round trip %+v != %+v", path, q, p)
+		}
+	})
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// route 路由表中的一项。pattern 按 / 分段匹配：
+// 普通段需完全相同，{name} 匹配任意单段，{name:int} 只匹配数字，{name...} 只能放在最后，匹配剩余的全部路径（可以为空）
+type route struct {
+	method  string
+	pattern string
+	group   string
+	handler func(c *Context)
+	// cache 缓存策略分类，默认按路由组选择
+	cache string
+
+	segments []string
+}
+
+type router struct {
+	routes []*route
+}
+
+type errorResponse struct {
+	Error   bool   `json:"error"`
+	Message string `json:"message"`
+}
+
+var appRouter = newRouter()
+
+func newRouter() *router {
+	r := &router{}
+	r.handle("GET", "/", "", func(c *Context) { c.String(200, renderIndex(conf().Domain)) })
+	r.handle("GET", "/favicon.ico", "", func(c *Context) { c.WriteHeader(404) })
+
+	r.handle("GET", "/api/illust", groupAPI, handleApiIllust)
+	r.handle("GET", "/api/search", groupAPI, handleApiSearch).withCache(cacheLists)
+	r.handle("GET", "/api/search_user", groupAPI, handleApiSearchUser).withCache(cacheLists)
+	r.handle("GET", "/api/tags/{pids}", groupAPI, handleApiTags)
+	r.handle("GET", "/api/rank", groupAPI, handleApiRank).withCache(cacheLists)
+	r.handle("GET", "/api/member_illust", groupAPI, handleApiMemberIllust).withCache(cacheLists)
+	r.handle("GET", "/api/related", groupAPI, handleApiRelated).withCache(cacheLists)
+	r.handle("GET", "/api/comments", groupAPI, handleApiComments).withCache(cacheLists)
+	r.handle("GET", "/api/novel", groupAPI, handleApiNovel)
+	r.handle("GET", "/api/novel_series", groupAPI, handleApiNovelSeries).withCache(cacheLists)
+	r.handle("GET", "/api/user_novels", groupAPI, handleApiUserNovels).withCache(cacheLists)
+	r.handle("GET", "/api/series", groupAPI, handleApiSeries).withCache(cacheLists)
+	r.handle("GET", "/api/epub", groupAPI, handleApiEpub)
+
+	r.handle("GET", "/feed/rank", groupFeed, handleFeedRank).withCache(cacheLists)
+	r.handle("GET", "/feed/search", groupFeed, handleFeedSearch).withCache(cacheLists)
+	r.handle("GET", "/feed/user/{uid:int}", groupFeed, handleFeedUser).withCache(cacheLists)
+
+	r.handle("POST", "/admin/reload", groupAdmin, handleAdminReload)
+	r.handle("GET", "/admin/sign", groupAdmin, handleAdminSign)
+	r.handle("GET", "/metrics", groupMetrics, handleMetrics)
+
+	r.handle("GET", "/i/{pid:int}", groupEmbed, renderEmbed)
+	r.handle("GET", "/i/{pid:int}/{page:int}", groupEmbed, renderEmbed)
+	r.handle("GET", "/oembed", groupEmbed, handleOEmbed)
+
+	r.handle("GET", "/_placeholder", groupImages, handlePlaceholder)
+	r.handle("GET", "/_s/{path...}", groupImages, handleStaticImage)
+	r.handle("GET", "/_embed/{path...}", groupImages, handleEmbedImage)
+	for _, t := range directTypes {
+		r.handle("GET", "/"+t+"/{path...}", groupImages, handleDirectImage)
+	}
+	r.handle("GET", "/{pid:int}", groupImages, handleIllustImage)
+	r.handle("GET", "/{pid:int}/{page:int}", groupImages, handleIllustImage)
+	return r
+}
+
+func (r *router) handle(method, pattern, group string, handler func(c *Context)) *route {
+	rt := &route{
+		method:   method,
+		pattern:  pattern,
+		group:    group,
+		handler:  handler,
+		segments: strings.Split(strings.TrimPrefix(pattern, "/"), "/"),
+	}
+	switch group {
+	case groupImages:
+		rt.cache = cacheImages
+	case groupAPI:
+		rt.cache = cacheAPI
+	}
+	r.routes = append(r.routes, rt)
+	return rt
+}
+
+func (rt *route) withCache(class string) *route {
+	rt.cache = class
+	return rt
+}
+
+func (rt *route) match(path string) (map[string]string, bool) {
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	var params map[string]string
+	for i, seg := range rt.segments {
+		if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "...}") {
+			if i >= len(parts) {
+				return nil, false
+			}
+			if params == nil {
+				params = map[string]string{}
+			}
+			params[seg[1:len(seg)-4]] = strings.Join(parts[i:], "/")
+			return params, true
+		}
+		if i >= len(parts) {
+			return nil, false
+		}
+		if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+			name, typ, _ := strings.Cut(seg[1:len(seg)-1], ":")
+			if parts[i] == "" || (typ == "int" && !isDigits(parts[i])) {
+				return nil, false
+			}
+			if params == nil {
+				params = map[string]string{}
+			}
+			params[name] = parts[i]
+			continue
+		}
+		if seg != parts[i] {
+			return nil, false
+		}
+	}
+	if len(parts) != len(rt.segments) {
+		return nil, false
+	}
+	return params, true
+}
+
+// lookup 按注册顺序匹配，返回方法也匹配的路由；路径匹配但方法不符时返回允许的方法
+func (r *router) lookup(method, path string) (*route, map[string]string, []string) {
+	var allowed []string
+	for _, rt := range r.routes {
+		params, ok := rt.match(path)
+		if !ok {
+			continue
+		}
+		if rt.method == method || (rt.method == "GET" && method == "HEAD") {
+			return rt, params, nil
+		}
+		if !in(allowed, rt.method) {
+			allowed = append(allowed, rt.method)
+			if rt.method == "GET" {
+				allowed = append(allowed, "HEAD")
+			}
+		}
+	}
+	return nil, nil, allowed
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// routeGroup 返回请求路径所属的路由组，首页和未知路径不属于任何组
+func routeGroup(path string) string {
+	for _, rt := range appRouter.routes {
+		if _, ok := rt.match(path); ok {
+			return rt.group
+		}
+	}
+	return ""
+}
+
+func (r *router) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	// 关闭超时后 cancelUpstream 会取消所有进行中的上游请求
+	ctx, cancel := context.WithCancel(req.Context())
+	defer cancel()
+	stop := context.AfterFunc(upstreamCtx, cancel)
+	defer stop()
+	req = req.WithContext(ctx)
+	c := &Context{
+		rw:  rw,
+		req: req,
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			buf := make([]byte, 64<<10)
+			buf = buf[:runtime.Stack(buf, false)]
+			log.Errorf("panic serving %s %s: %v\n%s", req.Method, req.URL.Path, err, buf)
+			c.Error(500, "internal server error")
+		}
+	}()
+	// 查询参数中可能有 api key 和签名，只记录路径
+	log.Info(req.Method, " ", req.URL.Path)
+	setSecurityHeaders(rw.Header(), conf().SecurityHeaders)
+	rt, params, allowed := r.lookup(req.Method, req.URL.Path)
+	if rt == nil {
+		if len(allowed) > 0 {
+			rw.Header().Set("Allow", strings.Join(allowed, ", "))
+			c.Error(405, "method not allowed")
+			return
+		}
+		c.Error(404, "route not found")
+		return
+	}
+	c.params = params
+	c.cache = rt.cache
+	rt.handler(c)
+}
+
+// Error 以与 pixiv ajax 相同的 {"error": true, "message": ...} 结构返回错误
+func (c *Context) Error(status int, msg string) {
+	b, _ := json.Marshal(errorResponse{Error: true, Message: msg})
+	c.rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	c.rw.Header().Set("Cache-Control", "no-store")
+	c.write(b, status)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// useConfig 在测试期间替换当前配置
+func useConfig(t *testing.T, cfg *Config) {
+	t.Helper()
+	old := currentConfig.Load()
+	currentConfig.Store(cfg)
+	t.Cleanup(func() { currentConfig.Store(old) })
+}
+
+func newTestRouter() *router {
+	r := &router{}
+	r.handle("GET", "/a/{id:int}", "", func(c *Context) { c.String(200, "int:"+c.Param("id")) })
+	r.handle("GET", "/a/{name}", "", func(c *Context) { c.String(200, "name:"+c.Param("name")) })
+	r.handle("POST", "/post", "", func(c *Context) { c.String(200, "post") })
+	r.handle("PUT", "/post", "", func(c *Context) { c.String(200, "put") })
+	r.handle("GET", "/files/{path...}", "", func(c *Context) { c.String(200, "path:"+c.Param("path")) })
+	r.handle("GET", "/panic", "", func(c *Context) { panic("boom") })
+	r.handle("GET", "/abort", "", func(c *Context) { panic(http.ErrAbortHandler) })
+	return r
+}
+
+func serveTest(r *router, method, target string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+	return rec
+}
+
+func decodeError(t *testing.T, rec *httptest.ResponseRecorder) errorResponse {
+	t.Helper()
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json; charset=utf-8" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	var e errorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &e); err != nil {
+		t.Fatalf("body %q: %v", rec.Body.String(), err)
+	}
+	if !e.Error || e.Message == "" {
+		t.Errorf("body = %+v", e)
+	}
+	return e
+}
+
+func TestRouterMatch(t *testing.T) {
+	useConfig(t, &Config{})
+	r := newTestRouter()
+	tests := []struct {
+		target string
+		status int
+		body   string
+	}{
+		{"/a/123", 200, "int:123"},
+		{"/a/abc", 200, "name:abc"},
+		{"/a/", 404, ""},
+		{"/a/1/2", 404, ""},
+		{"/a", 404, ""},
+		{"/files/", 200, "path:"},
+		{"/files/x", 200, "path:x"},
+		{"/files/x/y/z.png", 200, "path:x/y/z.png"},
+		{"/files", 404, ""},
+		{"/unknown", 404, ""},
+		{"/api", 404, ""},
+	}
+	for _, tt := range tests {
+		rec := serveTest(r, "GET", tt.target)
+		if rec.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.target, rec.Code, tt.status)
+			continue
+		}
+		if tt.status != 200 {
+			decodeError(t, rec)
+			continue
+		}
+		if rec.Body.String() != tt.body {
+			t.Errorf("%s: body %q, want %q", tt.target, rec.Body.String(), tt.body)
+		}
+	}
+}
+
+func TestRouterMethodNotAllowed(t *testing.T) {
+	useConfig(t, &Config{})
+	r := newTestRouter()
+	rec := serveTest(r, "GET", "/post")
+	if rec.Code != 405 {
+		t.Fatalf("status %d, want 405", rec.Code)
+	}
+	if allow := rec.Header().Get("Allow"); allow != "POST, PUT" {
+		t.Errorf("Allow = %q", allow)
+	}
+	decodeError(t, rec)
+
+	rec = serveTest(r, "DELETE", "/a/1")
+	if rec.Code != 405 || rec.Header().Get("Allow") != "GET, HEAD" {
+		t.Errorf("DELETE /a/1: status %d, Allow %q", rec.Code, rec.Header().Get("Allow"))
+	}
+}
+
+func TestRouterHead(t *testing.T) {
+	useConfig(t, &Config{})
+	r := newTestRouter()
+	rec := serveTest(r, "HEAD", "/a/1")
+	if rec.Code != 200 {
+		t.Errorf("HEAD /a/1: status %d", rec.Code)
+	}
+	if rec := serveTest(r, "HEAD", "/post"); rec.Code != 405 {
+		t.Errorf("HEAD /post: status %d, want 405", rec.Code)
+	}
+}
+
+func TestRouterPanic(t *testing.T) {
+	useConfig(t, &Config{})
+	out := log.StandardLogger().Out
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(out)
+	r := newTestRouter()
+	rec := serveTest(r, "GET", "/panic")
+	if rec.Code != 500 {
+		t.Fatalf("status %d, want 500", rec.Code)
+	}
+	decodeError(t, rec)
+
+	defer func() {
+		if err := recover(); err != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler", err)
+		}
+	}()
+	serveTest(r, "GET", "/abort")
+}
+
+func TestRouteGroups(t *testing.T) {
+	tests := map[string]string{
+		"/":                       "",
+		"/api/illust":             groupAPI,
+		"/api/tags/1,2":           groupAPI,
+		"/98505703":               groupImages,
+		"/98505703/2":             groupImages,
+		"/_s/common/images/x.png": groupImages,
+		"/i/98505703":             groupEmbed,
+		"/feed/user/11":           groupFeed,
+		"/admin/reload":           groupAdmin,
+		"/metrics":                groupMetrics,
+		"/feed/user/abc":          "",
+	}
+	for path, want := range tests {
+		if got := routeGroup(path); got != want {
+			t.Errorf("%s: group %q, want %q", path, got, want)
+		}
+	}
+}
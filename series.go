@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+
+	"go-pixiv-proxy/pixiv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// maxSeriesPages 获取漫画系列作品列表时最多请求的页数
+const maxSeriesPages = 100
+
+// seriesWorks 获取漫画系列中的全部作品，按系列中的顺序排列，first 为已获取的第一页
+func seriesWorks(ctx context.Context, id string, first *pixiv.SeriesPage) ([]pixiv.SeriesWork, error) {
+	works := append([]pixiv.SeriesWork{}, first.Page.Series...)
+	pages := (first.Page.Total + pixiv.SeriesPageSize - 1) / pixiv.SeriesPageSize
+	if pages > maxSeriesPages {
+		pages = maxSeriesPages
+	}
+	for p := 2; p <= pages; p++ {
+		page, err := pixivClient().Series(ctx, id, p)
+		if err != nil {
+			return nil, err
+		}
+		if len(page.Page.Series) == 0 {
+			break
+		}
+		works = append(works, page.Page.Series...)
+	}
+	sort.SliceStable(works, func(i, j int) bool { return works[i].Order < works[j].Order })
+	return works, nil
+}
+
+// illustPageURLs 作品各页的图片地址，与 /api/illust 中的 meta_pages 相同，original 为 false 时使用 regular
+func illustPageURLs(il *Illust, original bool) []string {
+	if len(il.MetaPages) == 0 {
+		if original && il.MetaSinglePage.OriginalImageURL != "" {
+			return []string{il.MetaSinglePage.OriginalImageURL}
+		}
+		return []string{il.ImageURLs.Large}
+	}
+	urls := make([]string, 0, len(il.MetaPages))
+	for _, p := range il.MetaPages {
+		if original && p.ImageURLs.Original != "" {
+			urls = append(urls, p.ImageURLs.Original)
+		} else {
+			urls = append(urls, p.ImageURLs.Large)
+		}
+	}
+	return urls
+}
+
+// mangaSeriesEpub 把漫画系列打包为固定版式的 EPUB，每页一张图片，
+// 各作品的页面与 /api/illust 相同，被过滤或已删除的作品会被跳过，
+// 与小说插图相同，下载失败的页面也会被跳过
+func (c *Context) mangaSeriesEpub(info *pixiv.IllustSeries, works []pixiv.SeriesWork, original bool) func(book *epubBook) error {
+	ctx := c.req.Context()
+	return func(book *epubBook) error {
+		book.Identifier = "https://www.pixiv.net/user/" + info.UserID + "/series/" + info.ID
+		book.Title = info.Title
+		book.Description = plainDescription(info.Caption)
+		book.Modified = parseDate(info.UpdateDate)
+		book.FixedLayout = true
+		book.RTL = true
+		for _, w := range works {
+			detail, err := GetArtWorkInfo(ctx, w.WorkID)
+			var apiErr *pixiv.APIError
+			if errors.As(err, &apiErr) {
+				log.Warnf("manga series %s: work %s: %v", info.ID, w.WorkID, err)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			il := &detail.Illust
+			if c.filter().blockedIllust(il) {
+				continue
+			}
+			if book.Author == "" {
+				book.Author = il.User.Name
+			}
+			listed := false
+			for i, u := range illustPageURLs(il, original) {
+				if u == "" {
+					continue
+				}
+				name := "w" + w.WorkID + "-p" + strconv.Itoa(i)
+				img, err := fetchEpubImage(ctx, u, name)
+				if err != nil {
+					log.Warnf("manga series %s: work %s page %d: %v", info.ID, w.WorkID, i, err)
+					continue
+				}
+				width, height := imageSize(img.Data)
+				if width == 0 {
+					width, height = il.Width, il.Height
+				}
+				if err := book.addImage(img); err != nil {
+					return err
+				}
+				if book.cover == "" {
+					book.cover = img.Name
+				}
+				err = book.addSection(epubSection{
+					Name:   name,
+					Title:  il.Title,
+					Body:   `<img class="page" src="../images/` + img.Name + `" alt=""/>` + "\n",
+					Hidden: listed, // 目录中只列出每个作品的第一页
+					Width:  width,
+					Height: height,
+				})
+				if err != nil {
+					return err
+				}
+				listed = true
+			}
+		}
+		return nil
+	}
+}
+
+// handleApiSeries 漫画系列信息和一页作品，作品按系列中的顺序排列，缩略图为代理地址。
+// 只有 pid 时返回该作品所属的系列中包含该作品的一页
+func handleApiSeries(c *Context) {
+	query := c.req.URL.Query()
+	id := query.Get("id")
+	page, ok := parsePage(query)
+	if pid := query.Get("pid"); id == "" && isDigits(pid) {
+		illust, err := pixivClient().Illust(c.req.Context(), pid)
+		if err != nil {
+			c.PixivError(err)
+			return
+		}
+		nav := illust.SeriesNavData
+		if nav == nil || nav.SeriesID == "" {
+			c.Error(404, "this work is not in a series")
+			return
+		}
+		id = nav.SeriesID
+		if query.Get("page") == "" && nav.Order > 0 {
+			page = (nav.Order-1)/pixiv.SeriesPageSize + 1
+		}
+	}
+	if !isDigits(id) || !ok {
+		c.Error(400, "id or page invalid")
+		return
+	}
+	res, err := pixivClient().Series(c.req.Context(), id, page)
+	if err != nil {
+		c.PixivError(err)
+		return
+	}
+	info := res.Info(id)
+	if info == nil {
+		c.Error(404, "series not found")
+		return
+	}
+	briefs := make(map[string]*pixiv.IllustBrief, len(res.Thumbnails.Illust))
+	for i := range res.Thumbnails.Illust {
+		briefs[res.Thumbnails.Illust[i].ID] = &res.Thumbnails.Illust[i]
+	}
+	works := append([]pixiv.SeriesWork{}, res.Page.Series...)
+	sort.SliceStable(works, func(i, j int) bool { return works[i].Order < works[j].Order })
+	series := &SeriesRef{ID: parseID(info.ID), Title: info.Title}
+	illusts := make([]Illust, 0, len(works))
+	var userName string
+	for _, w := range works {
+		b, ok := briefs[w.WorkID]
+		if !ok {
+			// 已删除或不可见的作品
+			continue
+		}
+		il := illustFromBrief(b)
+		il.ImageURLs.Large = c.proxyImageURL(il.ImageURLs.Large)
+		il.Series = series
+		illusts = append(illusts, il)
+		if userName == "" {
+			userName = b.UserName
+		}
+	}
+	total := res.Page.Total
+	if total == 0 {
+		total = info.Total
+	}
+	ret := &IllustSeriesList{
+		IllustSeriesDetail: IllustSeriesDetail{
+			ID:              parseID(info.ID),
+			Title:           info.Title,
+			Caption:         info.Caption,
+			CoverImageURLs:  ImageURLs{Medium: c.proxyImageURL(info.URL)},
+			SeriesWorkCount: total,
+			CreateDate:      info.CreateDate,
+			User:            User{ID: parseID(info.UserID), Name: userName},
+		},
+		IllustList: *newIllustList(illusts),
+	}
+	if page*pixiv.SeriesPageSize < total {
+		ret.NextURL = "id=" + id + "&page=" + strconv.Itoa(page+1)
+	}
+	c.filterList(&ret.IllustList)
+	c.JSON(200, ret)
+}
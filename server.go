@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// upstreamCtx 所有请求的 context 都会在它取消时一并取消，用于关闭超时后中断上游请求
+	upstreamCtx, cancelUpstream = context.WithCancel(context.Background())
+)
+
+type listener struct {
+	srv     *http.Server
+	network string
+	addr    string
+}
+
+func newServer(cfg *Config, handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: time.Duration(cfg.ReadHeaderTimeout),
+		IdleTimeout:       time.Duration(cfg.IdleTimeout),
+	}
+}
+
+// buildListeners 为每个监听配置生成监听（tcp 或 unix socket，可选 TLS）以及可选的 http 跳转监听
+func buildListeners(cfg *Config, handler http.Handler) ([]*listener, error) {
+	var listeners []*listener
+	for i, lc := range cfg.listeners() {
+		l := &listener{
+			srv:     newServer(cfg, newListenerHandler(i, handler)),
+			network: "tcp",
+			addr:    net.JoinHostPort(lc.Host, lc.Port),
+		}
+		if lc.Socket != "" {
+			l.network, l.addr = "unix", lc.Socket
+		}
+		if lc.TLSCert != "" || lc.TLSKey != "" {
+			tlsConfig, err := newTLSConfig(lc.TLSCert, lc.TLSKey)
+			if err != nil {
+				return nil, fmt.Errorf("listener %d: load certificate: %w", i, err)
+			}
+			l.srv.TLSConfig = tlsConfig
+		}
+		listeners = append(listeners, l)
+		if lc.RedirectAddr != "" {
+			if l.srv.TLSConfig == nil {
+				return nil, fmt.Errorf("listener %d: redirect_addr requires tls_cert and tls_key", i)
+			}
+			listeners = append(listeners, &listener{
+				srv:     newServer(cfg, newRedirectHandler(lc.Port)),
+				network: "tcp",
+				addr:    lc.RedirectAddr,
+			})
+		}
+	}
+	return listeners, nil
+}
+
+func (l *listener) String() string {
+	scheme := "http"
+	if l.srv.TLSConfig != nil {
+		scheme = "https"
+	}
+	if l.network == "unix" {
+		return scheme + "+unix://" + l.addr
+	}
+	return scheme + "://" + l.addr
+}
+
+func (l *listener) run() error {
+	if l.network == "unix" {
+		// 清理上次异常退出残留的 socket 文件
+		_ = os.Remove(l.addr)
+	}
+	ln, err := net.Listen(l.network, l.addr)
+	if err != nil {
+		return err
+	}
+	log.Info("listening on ", l)
+	if l.srv.TLSConfig != nil {
+		return l.srv.ServeTLS(ln, "", "")
+	}
+	return l.srv.Serve(ln)
+}
+
+// serve 启动所有监听并阻塞，收到 SIGINT/SIGTERM 后停止接收新连接，
+// 在 shutdown_timeout 内等待进行中的响应结束，超时则取消上游请求并强制关闭
+func serve(listeners []*listener) error {
+	errCh := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func(l *listener) {
+			errCh <- l.run()
+		}(l)
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	var runErr error
+	select {
+	case runErr = <-errCh:
+	case s := <-sig:
+		log.Infof("%s received, draining connections", s)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(conf().ShutdownTimeout))
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, l := range listeners {
+		wg.Add(1)
+		go func(srv *http.Server) {
+			defer wg.Done()
+			if err := srv.Shutdown(ctx); errors.Is(err, context.DeadlineExceeded) {
+				log.Warn("shutdown timeout, cancel upstream requests")
+				cancelUpstream()
+				_ = srv.Close()
+			}
+		}(l.srv)
+	}
+	wg.Wait()
+	cancelUpstream()
+	return runErr
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func mustParseURL(t *testing.T, raw string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatalf("parse %q: %v", raw, err)
+	}
+	return u
+}
+
+func TestSignURLRoundTrip(t *testing.T) {
+	s := &SigningConfig{Secrets: []string{"secret"}, TTL: Duration(time.Hour)}
+	for _, raw := range []string{"/98505703", "/98505703/2?t=small", "/img-original/img/2022/01/01/00/00/00/98505703_p0.png"} {
+		signed, err := signURL(s, raw, 0)
+		if err != nil {
+			t.Fatalf("sign %q: %v", raw, err)
+		}
+		if err := verifySignature(s, mustParseURL(t, signed)); err != nil {
+			t.Errorf("verify %q: %v", signed, err)
+		}
+	}
+}
+
+func TestSignURLNoSecrets(t *testing.T) {
+	if _, err := signURL(&SigningConfig{}, "/98505703", time.Hour); err == nil {
+		t.Error("sign without secrets: want error")
+	}
+}
+
+func TestImageTarget(t *testing.T) {
+	tests := []struct {
+		raw, want string
+	}{
+		{"/98505703?t=small", "/98505703?t=small"},
+		{"https://i.pximg.net/img-original/img/2022/05/01/00/00/00/98505703_p0.png", "/img-original/img/2022/05/01/00/00/00/98505703_p0.png"},
+		{"https://s.pximg.net/common/images/no_profile_s.png", "/_s/common/images/no_profile_s.png"},
+		{"https://embed.pixiv.net/decorate.php?illust_id=98505703", "/_embed/decorate.php?illust_id=98505703"},
+		{"https://booth.pximg.net/c/300x300/x.jpg", ""},
+		{"https://I.PXIMG.NET:443/img-original/img/2022/05/01/00/00/00/98505703_p0.png", ""},
+	}
+	for _, tt := range tests {
+		got, err := imageTarget(tt.raw)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%s: got %q, want error", tt.raw, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s: got %q, %v, want %q", tt.raw, got, err, tt.want)
+		}
+	}
+}
+
+func TestVerifySignatureExpired(t *testing.T) {
+	s := &SigningConfig{Secrets: []string{"secret"}}
+	u := mustParseURL(t, "/98505703")
+	query := url.Values{}
+	query.Set("exp", strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10))
+	query.Set("sig", signature("secret", u.EscapedPath(), query))
+	u.RawQuery = query.Encode()
+	if err := verifySignature(s, u); err != errSignatureExpired {
+		t.Errorf("verify expired: got %v, want %v", err, errSignatureExpired)
+	}
+}
+
+func TestVerifySignatureRotation(t *testing.T) {
+	old := &SigningConfig{Secrets: []string{"old"}}
+	signed, err := signURL(old, "/98505703?t=small", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := mustParseURL(t, signed)
+	rotated := &SigningConfig{Secrets: []string{"new", "old"}}
+	if err := verifySignature(rotated, u); err != nil {
+		t.Errorf("verify with rotated secrets: %v", err)
+	}
+	removed := &SigningConfig{Secrets: []string{"new"}}
+	if err := verifySignature(removed, u); err != errSignatureInvalid {
+		t.Errorf("verify after removing old secret: got %v, want %v", err, errSignatureInvalid)
+	}
+}
+
+func TestVerifySignatureTampered(t *testing.T) {
+	s := &SigningConfig{Secrets: []string{"secret"}}
+	signed, err := signURL(s, "/98505703?t=small", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		modify func(u *url.URL)
+		want   error
+	}{
+		{"query value", func(u *url.URL) { setQuery(u, "t", "original") }, errSignatureInvalid},
+		{"extra query", func(u *url.URL) { setQuery(u, "page", "2") }, errSignatureInvalid},
+		{"path", func(u *url.URL) { u.Path = "/98505704" }, errSignatureInvalid},
+		{"exp", func(u *url.URL) {
+			setQuery(u, "exp", strconv.FormatInt(time.Now().Add(48*time.Hour).Unix(), 10))
+		}, errSignatureInvalid},
+		{"bad exp", func(u *url.URL) { setQuery(u, "exp", "soon") }, errSignatureInvalid},
+		{"no sig", func(u *url.URL) { setQuery(u, "sig", "") }, errSignatureMissing},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := mustParseURL(t, signed)
+			tt.modify(u)
+			if err := verifySignature(s, u); err != tt.want {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func setQuery(u *url.URL, key, value string) {
+	query := u.Query()
+	query.Set(key, value)
+	u.RawQuery = query.Encode()
+}
+
+func TestValidateSignedListenerAuth(t *testing.T) {
+	secrets := SigningConfig{Secrets: []string{"secret"}}
+	tests := []struct {
+		name    string
+		l       ListenerConfig
+		wantErr bool
+	}{
+		{"default routes", ListenerConfig{RequireSignature: true}, true},
+		{"default routes with auth", ListenerConfig{RequireSignature: true, Auth: AuthPolicy{APIKeys: []string{"k"}}}, false},
+		{"images only", ListenerConfig{RequireSignature: true, Routes: []string{"images"}}, false},
+		{"api without auth", ListenerConfig{RequireSignature: true, Routes: []string{"images", "api"}}, true},
+		{"embed without auth", ListenerConfig{RequireSignature: true, Routes: []string{"images", "embed"}}, true},
+		{"feed without auth", ListenerConfig{RequireSignature: true, Routes: []string{"images", "feed"}}, true},
+		{"admin without auth", ListenerConfig{RequireSignature: true, Routes: []string{"images", "admin"}}, true},
+		{"admin with auth", ListenerConfig{RequireSignature: true, Routes: []string{"images", "admin"}, Auth: AuthPolicy{APIKeys: []string{"k"}}}, false},
+		{"basic auth", ListenerConfig{RequireSignature: true, Routes: []string{"images", "api"}, Auth: AuthPolicy{BasicAuth: map[string]string{"u": "p"}}}, false},
+		{"admin without signature", ListenerConfig{Routes: []string{"admin"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Domain: "https://example.com", Signing: secrets, Listeners: []ListenerConfig{tt.l}}
+			if err := cfg.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate: got %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateSplitListeners(t *testing.T) {
+	auth := AuthPolicy{APIKeys: []string{"k"}}
+	public := ListenerConfig{Port: "443", Routes: []string{"images"}, RequireSignature: true}
+	tests := []struct {
+		name    string
+		domain  string
+		api     ListenerConfig
+		wantErr bool
+	}{
+		{"internal api with auth", "https://img.example.com", ListenerConfig{Port: "8080", Routes: []string{"api"}, Auth: auth}, false},
+		{"internal api without auth", "https://img.example.com", ListenerConfig{Port: "8080", Routes: []string{"api"}}, true},
+		{"public embed without auth", "https://img.example.com", ListenerConfig{Port: "8080", Routes: []string{"embed"}}, true},
+		{"metrics only", "https://img.example.com", ListenerConfig{Port: "8080", Routes: []string{"metrics"}}, false},
+		{"no domain", "", ListenerConfig{Port: "8080", Routes: []string{"api"}, Auth: auth}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Domain:    tt.domain,
+				Signing:   SigningConfig{Secrets: []string{"secret"}},
+				Listeners: []ListenerConfig{public, tt.api},
+			}
+			if err := cfg.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate: got %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProxyURLSigning(t *testing.T) {
+	useConfig(t, &Config{
+		Domain:  "https://img.example.com",
+		Signing: SigningConfig{Secrets: []string{"secret"}, TTL: Duration(time.Hour)},
+		Listeners: []ListenerConfig{
+			{Port: "443", Routes: []string{"images"}, RequireSignature: true},
+			{Port: "8080", Routes: []string{"api"}, Auth: AuthPolicy{APIKeys: []string{"k"}}},
+		},
+	})
+	tests := []struct {
+		name       string
+		listener   int
+		key        string
+		wantSigned bool
+	}{
+		{"authenticated on api listener", 1, "k", true},
+		// 图片监听不鉴权，不能借它获得签名
+		{"image listener", 0, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			h := newListenerHandler(tt.listener, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got = (&Context{rw: rw, req: req}).proxyURL("/98505703?t=small")
+			}))
+			req := httptest.NewRequest("GET", "http://internal:8080/api/illust?pid=98505703", nil)
+			if tt.listener == 0 {
+				req = httptest.NewRequest("GET", "http://internal:443/98505703", nil)
+			}
+			if tt.key != "" {
+				req.Header.Set("X-API-Key", tt.key)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), req)
+			u := mustParseURL(t, got)
+			if u.Host != "img.example.com" {
+				t.Errorf("host %s, want domain", u.Host)
+			}
+			signed := u.Query().Get("sig") != ""
+			if signed != tt.wantSigned {
+				t.Fatalf("%s: signed %v, want %v", got, signed, tt.wantSigned)
+			}
+			if signed {
+				if err := verifySignature(&conf().Signing, u); err != nil {
+					t.Errorf("verify %s: %v", got, err)
+				}
+			}
+		})
+	}
+}
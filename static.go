@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// proxiedHosts 可以代理的 pixiv 图片域名，以及在代理上对应的路径前缀。
+// 其他 *.pximg.net 域名（booth、dic 等）的路径格式各不相同，不做代理
+var proxiedHosts = map[string]string{
+	"i.pximg.net":     "",
+	"i-cf.pximg.net":  "",
+	"s.pximg.net":     "/_s",
+	"embed.pixiv.net": "/_embed",
+}
+
+// staticPathRe s.pximg.net 上允许代理的路径：贴图、表情、默认头像等图片，不含 svg
+var staticPathRe = regexp.MustCompile(`^/(?:common|www)/images/(?:[0-9A-Za-z_-]+/)*[0-9A-Za-z_-]+\.(?:png|jpg|jpeg|gif)$`)
+
+// embedPathRe embed.pixiv.net 上允许代理的 OGP 图片
+var embedPathRe = regexp.MustCompile(`^/(?:artwork|decorate|novel|novel_series|series|user)\.php$`)
+
+// embedQueryKeys embed.pixiv.net 允许转发的参数，值只能为数字
+var embedQueryKeys = []string{"illust_id", "id", "user_id", "series_id", "page", "mdate"}
+
+// proxiedPath 把 pixiv 图片地址转为代理上的路径（含查询参数），不支持的地址返回 false。
+// 这里只替换域名，路径在请求代理时再校验
+func proxiedPath(u string) (string, bool) {
+	pu, err := url.Parse(u)
+	if err != nil || (pu.Scheme != "https" && pu.Scheme != "http") {
+		return "", false
+	}
+	prefix, ok := proxiedHosts[strings.ToLower(pu.Host)]
+	if !ok || !strings.HasPrefix(pu.EscapedPath(), "/") {
+		return "", false
+	}
+	p := prefix + pu.EscapedPath()
+	if pu.RawQuery != "" {
+		p += "?" + pu.RawQuery
+	}
+	return p, true
+}
+
+// imageTarget 把生成签名时传入的 pixiv 图片地址转为代理路径，不在 proxiedHosts 中的 *.pximg.net 地址返回错误，
+// 其他地址原样返回
+func imageTarget(raw string) (string, error) {
+	if p, ok := proxiedPath(raw); ok {
+		return p, nil
+	}
+	if pu, err := url.Parse(raw); err == nil {
+		if host := strings.ToLower(pu.Hostname()); host == "pximg.net" || strings.HasSuffix(host, ".pximg.net") {
+			return "", fmt.Errorf("image host %s is not supported", pu.Host)
+		}
+	}
+	return raw, nil
+}
+
+// proxyImageURL 把 pixiv 图片地址转为代理地址，其他地址原样返回
+func (c *Context) proxyImageURL(u string) string {
+	p, ok := proxiedPath(u)
+	if !ok {
+		return u
+	}
+	return c.proxyURL(p)
+}
+
+// wantsRewrite 是否替换 api 返回中的图片地址，请求参数 rewrite 优先于配置
+func (c *Context) wantsRewrite() bool {
+	switch c.req.URL.Query().Get("rewrite") {
+	case "1", "true":
+		return true
+	case "0", "false":
+		return false
+	}
+	return conf().RewriteURLs
+}
+
+// rewriteJSONURLs 把 JSON 中值为 pixiv 图片地址的字符串替换为代理地址，
+// 监听要求签名时附带签名参数
+func (c *Context) rewriteJSONURLs(b []byte) []byte {
+	var out []byte
+	last := 0
+	for i := 0; i < len(b); i++ {
+		if b[i] != '"' {
+			continue
+		}
+		// 找到字符串的结尾，跳过转义字符
+		j := i + 1
+		for j < len(b) && b[j] != '"' {
+			if b[j] == '\\' {
+				j++
+			}
+			j++
+		}
+		if j >= len(b) {
+			break
+		}
+		if lit := b[i : j+1]; bytes.HasPrefix(lit, []byte(`"http`)) {
+			var s string
+			if json.Unmarshal(lit, &s) == nil {
+				if p, ok := proxiedPath(s); ok {
+					enc, _ := json.Marshal(c.proxyURL(p))
+					out = append(append(out, b[last:i]...), enc...)
+					last = j + 1
+				}
+			}
+		}
+		i = j
+	}
+	if out == nil {
+		return b
+	}
+	return append(out, b[last:]...)
+}
+
+// staticURL s.pximg.net 上的路径的代理地址
+func (c *Context) staticURL(path string) string {
+	return c.proxyURL("/_s" + path)
+}
+
+// handleStaticImage 代理 s.pximg.net 上的贴图、表情和默认头像等图片
+func handleStaticImage(c *Context) {
+	if !c.checkSignature() {
+		return
+	}
+	p := strings.TrimPrefix(c.req.URL.Path, "/_s")
+	if !staticPathRe.MatchString(p) {
+		c.Error(400, errPximgPath.Error())
+		return
+	}
+	proxyStream(c, pixivClient().StaticBaseURL+p, "fetch pixiv image error", conf().ImageHeaders)
+}
+
+// handleEmbedImage 代理 embed.pixiv.net 上的 OGP 图片，只转发规范化后的数字参数，
+// 带作品 id 时与直链一样检查内容过滤
+func handleEmbedImage(c *Context) {
+	if !c.checkSignature() {
+		return
+	}
+	p := strings.TrimPrefix(c.req.URL.Path, "/_embed")
+	if !embedPathRe.MatchString(p) {
+		c.Error(400, errPximgPath.Error())
+		return
+	}
+	query := c.req.URL.Query()
+	forward := url.Values{}
+	for _, k := range embedQueryKeys {
+		v := query.Get(k)
+		if v == "" {
+			continue
+		}
+		if !isDigits(v) {
+			c.Error(400, errPximgPath.Error())
+			return
+		}
+		forward.Set(k, v)
+	}
+	if pid := forward.Get("illust_id"); pid != "" && c.filter() != nil {
+		illust, err := pixivClient().Illust(c.req.Context(), pid)
+		if err != nil {
+			c.PixivError(err)
+			return
+		}
+		if c.blockedWork(illust) {
+			c.rejectFiltered()
+			return
+		}
+	}
+	u := pixivClient().EmbedBaseURL + p
+	if len(forward) > 0 {
+		u += "?" + forward.Encode()
+	}
+	proxyStream(c, u, "fetch pixiv image error", conf().ImageHeaders)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// certCheckInterval 两次检查证书文件修改时间的最小间隔
+const certCheckInterval = 10 * time.Second
+
+// certReloader 在握手时按需重新加载磁盘上被更新的证书，便于配合 certbot 等外部续签工具
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) load() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.cert = &cert
+	r.modTime = r.latestModTime()
+	return nil
+}
+
+func (r *certReloader) latestModTime() time.Time {
+	var t time.Time
+	for _, f := range []string{r.certFile, r.keyFile} {
+		if fi, err := os.Stat(f); err == nil && fi.ModTime().After(t) {
+			t = fi.ModTime()
+		}
+	}
+	return t
+}
+
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.lastCheck) >= certCheckInterval {
+		r.lastCheck = time.Now()
+		if r.latestModTime().After(r.modTime) {
+			if err := r.load(); err != nil {
+				log.Warn("reload certificate failed, keep old certificate: ", err)
+			} else {
+				log.Info("certificate reloaded: ", r.certFile)
+			}
+		}
+	}
+	return r.cert, nil
+}
+
+func newTLSConfig(certFile, keyFile string) (*tls.Config, error) {
+	r, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		NextProtos:     []string{"h2", "http/1.1"},
+		GetCertificate: r.GetCertificate,
+	}, nil
+}
+
+// newRedirectHandler 将 http 请求 301 到 https，httpsPort 为 443 时省略端口
+func newRedirectHandler(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		h := req.Host
+		if sh, _, err := net.SplitHostPort(h); err == nil {
+			h = sh
+		}
+		if httpsPort != "" && httpsPort != "443" {
+			h = net.JoinHostPort(h, httpsPort)
+		}
+		http.Redirect(rw, req, "https://"+h+req.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}